@@ -28,20 +28,31 @@ import (
 type (
 	Config struct {
 		RedisAddr           string         // default redis://@127.0.0.1:6379/0
+		StoreFile           string         // directory of the embedded file store, which is used instead of redis if set
+		Namespace           string         // prefix of all the keys in the state store
 		RunningLockTTL      int64          // TTL for running lock key in redis (seconds)
 		SendingLockTTL      int64          // TTL for sending lock key in redis (seconds)
 		SrcFetchInterval    int64          // in seconds
-		SrcRpcAddr          string         // no default (ip:port)
+		SrcRpcAddrs         []string       // no default (ip:port)
+		SrcQuorum           int            // number of source nodes must agree on block hashes, <=1 for no cross-checking
+		SrcConcurrency      int            // max number of concurrent proof requests of a block
+		SrcPrefilter        string         // how the txs are selected before fetching their proofs
+		SrcPrefetch         int            // max number of batches of blocks fetched ahead, 0 for no prefetching
+		SrcWatchInterval    int64          // in milliseconds, 0 for no watching of the source head
 		SrcChainId          common.ChainID // 0 for maintainer
 		SrcStartHeight      uint64         // start height
 		SrcIgnoreBlocks     bool           // ignore blocks where its BlockNum<(EpochLength-100) in maintaining
 		TargetName          string         // the unique name
-		TargetApiAddr       string         // target chain eth_api address, no default (ip:addr)
+		TargetApiAddrs      []string       // target chain eth_api addresses in priority order, no default (ip:addr)
 		TargetChainID       *big.Int       // target chain id
 		TargetRetryInterval int64          // retry to fetch receipt from target chain, in seconds
 		TargetIsTKM         bool           // target chain is a Thinkium chain (for testing)
 		TargetGPTTL         int64          // TTL of gasprice cache for target chain in seconds
 		TargetCheckBalance  bool           // whether to check the balance in target
+		TargetFees          *FeePolicy     // tx type and fee caps of target chain
+		TargetReplace       *ReplacePolicy // replacement of stuck txs in target chain
+		TargetGas           *GasPolicy     // gas limit of txs in target chain
+		DryRun              bool           // simulate the txs instead of sending them, with the states in a separate namespace
 		Maintainer          Maintain
 		Synchronizer        Synchronize
 		Updater             Update
@@ -59,20 +70,24 @@ type (
 	}
 
 	Synchronize struct {
-		TkmChainId    *big.Int       // chain id of source chain of cross-chain tx
-		TkmMCSAddress common.Address // address of contract map-cross-chain-service in source tkm chain
-		TargetMSCAddr common.Address // address of contract map-cross-chain-service in target chain
-		TargetLCAddr  common.Address // address of contract TKM Light-Node in target chain
-		UpdatableLC   bool           // if the TKM Light-Node is updatable
-		MaxHeightTTL  int64          // TTL for cache of max validatable sub-chain height in TKM-Light-Node
+		TkmChainId    *big.Int         // chain id of source chain of cross-chain tx
+		TkmMCSAddress common.Address   // address of contract map-cross-chain-service in source tkm chain
+		TargetMSCAddr common.Address   // address of contract map-cross-chain-service in target chain
+		TargetLCAddr  common.Address   // address of contract TKM Light-Node in target chain
+		UpdatableLC   bool             // if the TKM Light-Node is updatable
+		MaxHeightTTL  int64            // TTL for cache of max validatable sub-chain height in TKM-Light-Node
+		Preflight     bool             // verify proofs and simulate transferIn before sending
+		Routers       []common.Address // contracts calling the MCS in source tkm chain
 	}
 
 	XSynchronize struct {
-		XChainId      *big.Int       // chain id of source chain of cross-chain tx
-		XMCSAddress   common.Address // address of contract map-cross-chain-service in source X-Relay chain
-		TargetMSCAddr common.Address // address of contract map-cross-chain-service in target chain
-		TargetLCAddr  common.Address // address of contract X-Light-Node in target chain
-		MaxHeightTTL  int64          // TTL for cache of max validatable X-Relay height in X-Light-Node
+		XChainId      *big.Int         // chain id of source chain of cross-chain tx
+		XMCSAddress   common.Address   // address of contract map-cross-chain-service in source X-Relay chain
+		TargetMSCAddr common.Address   // address of contract map-cross-chain-service in target chain
+		TargetLCAddr  common.Address   // address of contract X-Light-Node in target chain
+		MaxHeightTTL  int64            // TTL for cache of max validatable X-Relay height in X-Light-Node
+		Preflight     bool             // verify proofs and simulate transferIn before sending
+		Routers       []common.Address // contracts calling the MCS in source X-Relay chain
 	}
 
 	Update struct {
@@ -109,6 +124,9 @@ func (s Synchronize) validate() error {
 	if s.TargetMSCAddr == common.EmptyAddress {
 		return errors.New("target MapCrossChainService contract address missing")
 	}
+	if s.Preflight && s.TargetLCAddr == common.EmptyAddress {
+		return errors.New("target light node contract address is required by preflight")
+	}
 	return nil
 }
 
@@ -122,6 +140,9 @@ func (s XSynchronize) validate() error {
 	if s.TargetMSCAddr == common.EmptyAddress {
 		return errors.New("target MapCrossChainService contract address missing")
 	}
+	if s.Preflight && s.TargetLCAddr == common.EmptyAddress {
+		return errors.New("target light node contract address is required by preflight")
+	}
 	return nil
 }
 
@@ -154,10 +175,29 @@ var (
 	_redisFlag = altsrc.NewStringFlag(&cli.StringFlag{
 		Name:     "redis",
 		Category: BasicCategory,
-		Usage:    "redis server address",
+		Usage:    "redis server `URL`, redis-sentinel://host:port,host:port/db?master=NAME for sentinel, redis-cluster://host:port,host:port for cluster",
 		Value:    "redis://@127.0.0.1:6379/0",
 	})
 
+	_storeFileFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:     "store.file",
+		Category: BasicCategory,
+		Usage:    "keep states in an embedded database in `DIR` instead of redis, for single node deployments",
+	})
+
+	_namespaceFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:     "namespace",
+		Category: BasicCategory,
+		Usage:    "`PREFIX` of all the keys in the state store, for environments sharing one store",
+	})
+
+	_dryRunFlag = altsrc.NewBoolFlag(&cli.BoolFlag{
+		Name:     "dry-run",
+		Category: BasicCategory,
+		Usage: "simulate every tx to the target chain by eth_call instead of signing and sending it, " +
+			"the cursors and the last update time are kept in the \"" + dryRunNamespace + "\" namespace",
+	})
+
 	_ttlRunningLcokFlag = altsrc.NewInt64Flag(&cli.Int64Flag{
 		Name:     "runningLockTTL",
 		Category: BasicCategory,
@@ -186,6 +226,12 @@ var (
 		Value:    5,
 	})
 
+	_httpFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:     "http",
+		Category: BasicCategory,
+		Usage:    "listen on `ADDRESS` (such as :9090) for the HTTP endpoints, /metrics exports Prometheus metrics, /healthz and /readyz for probes",
+	})
+
 	_logFileFlag = altsrc.NewStringFlag(&cli.StringFlag{
 		Name:     "log",
 		Category: BasicCategory,
@@ -204,7 +250,41 @@ var (
 	_srcRpcFlag = altsrc.NewStringFlag(&cli.StringFlag{
 		Name:     "src.rpc",
 		Category: SourceCategory,
-		Usage:    "rpc address of source chain node",
+		Usage:    "rpc addresses of source chain nodes, separated by comma",
+	})
+
+	_srcQuorumFlag = altsrc.NewUintFlag(&cli.UintFlag{
+		Name:     "src.quorum",
+		Category: SourceCategory,
+		Usage:    "if `QUORUM`>1, block hashes must be the same in at least QUORUM source nodes before processing",
+	})
+
+	_srcConcurrencyFlag = altsrc.NewUintFlag(&cli.UintFlag{
+		Name:     "src.concurrency",
+		Category: SourceCategory,
+		Usage:    "max number of the proofs of a block fetched and verified concurrently by (x)sync",
+		Value:    4,
+	})
+
+	_srcPrefilterFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:     "src.prefilter",
+		Category: SourceCategory,
+		Usage: "how (x)sync selects the contract calls before fetching their proofs, `MODE`: receipt (the receipt has " +
+			"the event of the MCS), to (the tx calls the MCS or one of the routers) or none",
+		Value: prefilterReceipt,
+	})
+
+	_srcPrefetchFlag = altsrc.NewUintFlag(&cli.UintFlag{
+		Name:     "src.prefetch",
+		Category: SourceCategory,
+		Usage:    "max number of batches of blocks fetched ahead while processing, 0 for fetching after processing",
+	})
+
+	_srcWatchFlag = altsrc.NewUintFlag(&cli.UintFlag{
+		Name:     "src.watch",
+		Category: SourceCategory,
+		Usage: "interval in milliseconds of watching the current height of the source chain, the loopers start " +
+			"fetching once it moves without waiting for the next interval, 0 for no watching",
 	})
 
 	_srcChainFlag = altsrc.NewUintFlag(&cli.UintFlag{
@@ -247,7 +327,7 @@ var (
 	_targetApiFlag = altsrc.NewStringFlag(&cli.StringFlag{
 		Name:     "target.api",
 		Category: TargetCategory,
-		Usage:    "Ethereum-like API address of target chain",
+		Usage:    "Ethereum-like API addresses of target chain, separated by comma in priority order",
 	})
 
 	_targetChainIDFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
@@ -289,6 +369,67 @@ var (
 		Value:    60 * 10,
 	})
 
+	_targetTxTypeFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:     "target.txtype",
+		Category: TargetCategory,
+		Usage:    "`TYPE` of tx sent to target chain: legacy, dynamic (EIP-1559) or auto (dynamic if the latest header has BaseFee)",
+		Value:    TxTypeLegacy,
+	})
+
+	_targetMaxFeeFlag = altsrc.NewFloat64Flag(&cli.Float64Flag{
+		Name:     "target.maxfee",
+		Category: TargetCategory,
+		Usage:    "max fee per gas of dynamic-fee tx, or max gas price of legacy tx (in gwei), 0 for unlimited",
+	})
+
+	_targetMaxTipFlag = altsrc.NewFloat64Flag(&cli.Float64Flag{
+		Name:     "target.maxtip",
+		Category: TargetCategory,
+		Usage:    "max priority fee per gas of dynamic-fee tx (in gwei), 0 for unlimited",
+	})
+
+	_targetReplaceWaitFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
+		Name:     "target.replacewait",
+		Category: TargetCategory,
+		Usage:    "`SECONDS` to wait for the receipt before replacing the tx with higher fees, 0 for never",
+		Value:    60,
+	})
+
+	_targetBumpPercentFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
+		Name:     "target.bumppercent",
+		Category: TargetCategory,
+		Usage:    "`PERCENT` of fees increased when replacing a stuck tx (at least 10)",
+		Value:    20,
+	})
+
+	_targetMaxBumpsFlag = altsrc.NewUintFlag(&cli.UintFlag{
+		Name:     "target.maxbumps",
+		Category: TargetCategory,
+		Usage:    "max number of replacements of a stuck tx",
+		Value:    3,
+	})
+
+	_targetGasMultiplierFlag = altsrc.NewFloat64Flag(&cli.Float64Flag{
+		Name:     "target.gasmultiplier",
+		Category: TargetCategory,
+		Usage:    "the estimated gas is multiplied by `MULTIPLIER` as the gas limit of a tx",
+		Value:    1.2,
+	})
+
+	_targetGasFloorFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
+		Name:     "target.gasfloor",
+		Category: TargetCategory,
+		Usage:    "the minimum gas limit of a tx",
+		Value:    21000,
+	})
+
+	_targetGasCeilingFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
+		Name:     "target.gasceiling",
+		Category: TargetCategory,
+		Usage:    "the maximum gas limit of a tx, also used when the estimation is not available",
+		Value:    defaultGas,
+	})
+
 	_targetCheckBalance = altsrc.NewBoolFlag(&cli.BoolFlag{
 		Name:     "target.checkbalance",
 		Category: TargetCategory,
@@ -332,6 +473,18 @@ var (
 		Usage:    "whether the Thinkium Light-Client is updatable by admin",
 	})
 
+	_syncPreflightFlag = altsrc.NewBoolFlag(&cli.BoolFlag{
+		Name:     "sync.preflight",
+		Category: SyncFlagCategory,
+		Usage:    "verify each proof with the light node and simulate transferIn before sending, the reverted ones are never sent",
+	})
+
+	_syncRoutersFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:     "sync.routers",
+		Category: SyncFlagCategory,
+		Usage:    "comma separated addresses of the contracts calling the MCS on source Thinkium chain, their txs always pass the prefilter",
+	})
+
 	_syncMaxHeightTTLFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
 		Name:     "sync.maxheightttl",
 		Category: SyncFlagCategory,
@@ -367,6 +520,52 @@ var (
 		Aliases:  []string{"p"},
 	}
 
+	_keysRunnerFlag = &cli.StringFlag{
+		Name:     "keys.runner",
+		Usage:    "`TYPE` of the runner whose states are concerned: maintain, sync, update, xmaintain or xsync",
+		Required: true,
+	}
+
+	_keysSenderFlag = &cli.StringFlag{
+		Name:  "keys.sender",
+		Usage: "`ADDRESS` of the sender in target chain, for the tx journal. The journal is not concerned if not set",
+	}
+
+	_keysFileFlag = &cli.StringFlag{
+		Name:  "keys.file",
+		Usage: "exported states `FILE`, stdout for export if not set",
+	}
+
+	_keysForceFlag = &cli.BoolFlag{
+		Name:  "keys.force",
+		Usage: "overwrite the existing states when importing or migrating",
+	}
+
+	_keysToNamespaceFlag = &cli.StringFlag{
+		Name:  "keys.tonamespace",
+		Usage: "migrate to the `NAMESPACE`",
+	}
+
+	_keysToNameFlag = &cli.StringFlag{
+		Name:  "keys.toname",
+		Usage: "migrate to the target `NAME`",
+	}
+
+	_keysDeleteFlag = &cli.BoolFlag{
+		Name:  "keys.delete",
+		Usage: "delete the migrated states from the source",
+	}
+
+	_serveRoutesFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:  "serve.routes",
+		Usage: "YAML `FILE` of the routes list, each route has a runner type at \"type\" and its flags overriding the ones of the command",
+	})
+
+	_statusJSONFlag = &cli.BoolFlag{
+		Name:  "status.json",
+		Usage: "print the status in JSON for scripting",
+	}
+
 	_pemOutputFlag = &cli.StringFlag{
 		Name:    "output",
 		Usage:   "output PEM `FILE_PATH`",
@@ -388,7 +587,7 @@ var (
 	_xmaintainSyncStartHeightKeyFlag = altsrc.NewStringFlag(&cli.StringFlag{
 		Name:     "xmaintain.xsyncstartheightkey",
 		Category: XMaintainFlagCategory,
-		Usage:    "the redis key of the corresponding xsync start height key",
+		Usage:    "the key of the corresponding xsync start height, without namespace",
 	})
 
 	_xSyncChainIDFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
@@ -397,6 +596,12 @@ var (
 		Usage:    "the x-relay ETH-ChainID used for target mcs.transferIn function",
 	})
 
+	_xSyncRoutersFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:     "xsync.routers",
+		Category: XSyncFlagCategory,
+		Usage:    "comma separated addresses of the contracts calling the MCS on X-Relay chain, their txs always pass the prefilter",
+	})
+
 	_xSyncMCSFlag = altsrc.NewStringFlag(&cli.StringFlag{
 		Name:     "xsync.mcs",
 		Category: XSyncFlagCategory,
@@ -415,6 +620,12 @@ var (
 		Usage:    "the address of X-Relay Light-Client contract on target chain",
 	})
 
+	_xSyncPreflightFlag = altsrc.NewBoolFlag(&cli.BoolFlag{
+		Name:     "xsync.preflight",
+		Category: XSyncFlagCategory,
+		Usage:    "verify each proof with the X-Relay light node and simulate transferIn before sending, the reverted ones are never sent",
+	})
+
 	_xSyncMaxHeightTTLFlag = altsrc.NewUint64Flag(&cli.Uint64Flag{
 		Name:     "xsync.maxheightttl",
 		Category: XSyncFlagCategory,
@@ -425,10 +636,18 @@ var (
 	_allFlags = []cli.Flag{
 		_confFileFlag,
 		_redisFlag,
+		_storeFileFlag,
+		_namespaceFlag,
+		_dryRunFlag,
 		_ttlRunningLcokFlag,
 		_ttlSendingLockFlag,
 		_intervalFlag,
 		_srcRpcFlag,
+		_srcQuorumFlag,
+		_srcConcurrencyFlag,
+		_srcPrefilterFlag,
+		_srcPrefetchFlag,
+		_srcWatchFlag,
 		_srcChainFlag,
 		_srcBaseChainIDFlag,
 		_srcBlocksInEpochFlag,
@@ -443,8 +662,18 @@ var (
 		_targetIsTKM,
 		_targetGPTTL,
 		_targetCheckBalance,
+		_targetTxTypeFlag,
+		_targetMaxFeeFlag,
+		_targetMaxTipFlag,
+		_targetReplaceWaitFlag,
+		_targetBumpPercentFlag,
+		_targetMaxBumpsFlag,
+		_targetGasMultiplierFlag,
+		_targetGasFloorFlag,
+		_targetGasCeilingFlag,
 		_retryIntervalFlag,
 		_logFileFlag,
+		_httpFlag,
 		_checkLNCommFlag,
 	}
 
@@ -459,6 +688,8 @@ var (
 		_syncTargetLCFlag,
 		_syncUpdatableLCFlag,
 		_syncMaxHeightTTLFlag,
+		_syncPreflightFlag,
+		_syncRoutersFlag,
 	}
 
 	_updateFlags = []cli.Flag{
@@ -473,6 +704,19 @@ var (
 		_pemInputFlag,
 	}
 
+	_keysFlags = []cli.Flag{
+		_keysRunnerFlag,
+		_keysSenderFlag,
+	}
+
+	// status of all the configured runners
+	_statusFlags = joinFlags([]cli.Flag{_statusJSONFlag},
+		_maintainFlags, _syncFlags, _updateFlags, _xmaintainFlags, _xSyncFlags)
+
+	// all runners of the serve command share these flags as their defaults
+	_serveFlags = joinFlags([]cli.Flag{_serveRoutesFlag},
+		_maintainFlags, _syncFlags, _updateFlags, _xmaintainFlags, _xSyncFlags)
+
 	_xmaintainFlags = []cli.Flag{
 		_xmaintainTargetLCFlag,
 		_xmaintainSyncStartHeightKeyFlag,
@@ -484,9 +728,31 @@ var (
 		_xSyncTargetMCSFlag,
 		_xSyncTargetLCFlag,
 		_xSyncMaxHeightTTLFlag,
+		_xSyncPreflightFlag,
+		_xSyncRoutersFlag,
 	}
 )
 
+func stringToAddresses(ctx *cli.Context, name string) ([]common.Address, error) {
+	var addrs []common.Address
+	for _, s := range splitAddrs(ctx.String(name)) {
+		bs, err := hex.DecodeString(s)
+		if err != nil || len(bs) != common.AddressLength {
+			return nil, fmt.Errorf("invalid %s: %s", name, s)
+		}
+		addrs = append(addrs, common.BytesToAddress(bs))
+	}
+	return addrs, nil
+}
+
+func joinFlags(flagss ...[]cli.Flag) []cli.Flag {
+	var flags []cli.Flag
+	for _, fs := range flagss {
+		flags = append(flags, fs...)
+	}
+	return flags
+}
+
 func stringToAddress(ctx *cli.Context, name string) (common.Address, error) {
 	bs, err := hex.DecodeString(ctx.String(name))
 	if err != nil || len(bs) != common.AddressLength {
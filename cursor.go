@@ -0,0 +1,304 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
+)
+
+// cursorHandler reads and writes the cursor of a runner: the start height of the loopers, or the
+// last update time (unix seconds) of the updater.
+type cursorHandler interface {
+	basicHandler
+	base() *runner
+	getCursor(cctx *cli.Context) (cursor uint64, stored bool, err error)
+	setCursor(cctx *cli.Context, cursor uint64) error
+	// checkCursor returns an error if the cursor should not be written
+	checkCursor(cctx *cli.Context, cursor uint64) error
+}
+
+func (a *runner) base() *runner {
+	return a
+}
+
+// cursorRange returns the range of start heights of a (x)maintainer that could be used with the
+// lastHeight of the light node, as confirmConfig does.
+func cursorRange(lastHeight common.Height) (min, max common.Height) {
+	return lastHeight + 1, lastHeight + common.Height(common.BlocksInEpoch)
+}
+
+func checkCursorRange(cursor, lastHeight common.Height) error {
+	min, max := cursorRange(lastHeight)
+	if cursor.Compare(min) < 0 || cursor.Compare(max) > 0 {
+		return fmt.Errorf("cursor:%s out of [%s, %s] with light-node.lastHeight:%s", &cursor, &min, &max, &lastHeight)
+	}
+	return nil
+}
+
+func (a *looper) getCursor(cctx *cli.Context) (uint64, bool, error) {
+	h, exist, err := a.store.Cursor(cctx.Context, a.keys.startHeightKey)
+	if err != nil {
+		return 0, false, err
+	}
+	if !exist {
+		return a.conf.SrcStartHeight, false, nil
+	}
+	return h, true, nil
+}
+
+func (a *looper) setCursor(cctx *cli.Context, cursor uint64) error {
+	return a.updateStartHeight(cctx, common.Height(cursor))
+}
+
+func (a *looper) checkCursor(_ *cli.Context, _ uint64) error {
+	return nil
+}
+
+func (a *maintainer) checkCursor(cctx *cli.Context, cursor uint64) error {
+	lastHeight, err := a._lastHeight(cctx.Context)
+	if err != nil {
+		return err
+	}
+	return checkCursorRange(common.Height(cursor), lastHeight)
+}
+
+func (a *xmaintainer) checkCursor(cctx *cli.Context, cursor uint64) error {
+	lastHeight, err := a._lastHeight(cctx.Context)
+	if err != nil {
+		return err
+	}
+	return checkCursorRange(common.Height(cursor), lastHeight)
+}
+
+func (n *syncer) checkCursor(cctx *cli.Context, cursor uint64) error {
+	maxMain, maxSub, err := n._maxProvableHeights(cctx.Context)
+	if err != nil {
+		return err
+	}
+	if h := common.Height(cursor); h.Compare(maxSub) > 0 {
+		log.Warnf("cursor:%s is above the max provable height: Main:%s, Sub:%s", &h, &maxMain, &maxSub)
+	}
+	return nil
+}
+
+func (n *xsyncer) checkCursor(cctx *cli.Context, cursor uint64) error {
+	max, err := n._maxProvableHeight(cctx.Context)
+	if err != nil {
+		return err
+	}
+	if h := common.Height(cursor); h.Compare(max) > 0 {
+		log.Warnf("cursor:%s is above the max provable height: %s", &h, &max)
+	}
+	return nil
+}
+
+func (u *updater) getCursor(cctx *cli.Context) (uint64, bool, error) {
+	value, exist, err := u.store.Get(cctx.Context, u.lastUpdateTimeKey)
+	if err != nil || !exist {
+		return 0, false, err
+	}
+	t, err := strconv.ParseUint(value, 10, 64)
+	return t, err == nil, err
+}
+
+func (u *updater) setCursor(cctx *cli.Context, cursor uint64) error {
+	_, err := u._updateToLastUpdateTimeInCache(cctx.Context, int64(cursor))
+	return err
+}
+
+func (u *updater) checkCursor(_ *cli.Context, cursor uint64) error {
+	if cursor > uint64(time.Now().Unix()) {
+		log.Warnf("last update time %s is in the future, no update until then", unixSecondsString(int64(cursor)))
+	}
+	return nil
+}
+
+// newRunnerOfType creates a runner of the type with its handlers set
+func newRunnerOfType(runnerType string) (h cursorHandler, err error) {
+	defer func() {
+		if h != nil {
+			h.base().runnerType = runnerType
+		}
+	}()
+	switch runnerType {
+	case "maintain":
+		a := &maintainer{}
+		a.bHandler, a.lHander = a, a
+		return a, nil
+	case "sync":
+		a := &syncer{}
+		a.bHandler, a.lHander = a, a
+		return a, nil
+	case "update":
+		a := &updater{}
+		a.bHandler = a
+		return a, nil
+	case "xmaintain":
+		a := &xmaintainer{}
+		a.bHandler, a.lHander = a, a
+		return a, nil
+	case "xsync":
+		a := &xsyncer{}
+		a.bHandler, a.lHander = a, a
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unknown runner type: %q", runnerType)
+	}
+}
+
+// _withRunner starts the runner without confirming the config or reconciling its journal, and
+// calls fn with it
+func _withRunner(cctx *cli.Context, runnerType string, fn func(h cursorHandler) error) error {
+	h, err := newRunnerOfType(runnerType)
+	if err != nil {
+		return cli.Exit(err, ExitByInput)
+	}
+	initGlobals(cctx)
+	if err := h.prepareConfig(cctx); err != nil {
+		return err
+	}
+	a := h.base()
+	a.needs = a.needs.Clear(NeedConfirm)
+	if !a.needs.Bool(NeedRedis) {
+		return cli.Exit(errors.New("state store not used"), ExitByConfig)
+	}
+	defer func() {
+		_ = a.close(cctx)
+	}()
+	if err := a.start(cctx); err != nil {
+		return err
+	}
+	return fn(h)
+}
+
+// _writeCursor writes the cursor with the running lock held, so that it would not be overwritten
+// by a running runner.
+func _writeCursor(cctx *cli.Context, h cursorHandler, cursor func(current uint64) (uint64, error)) error {
+	a := h.base()
+	if holder, err := a.runningLock.Fetch(cctx.Context); err != nil {
+		return cli.Exit(fmt.Errorf("%s is running by [%s]: %w", h.Name(), holder, err), ExitRunningLockErr)
+	}
+	defer func() {
+		_ = a.runningLock.Release()
+	}()
+	current, _, err := h.getCursor(cctx)
+	if err != nil {
+		return cli.Exit(fmt.Errorf("get cursor failed: %w", err), ExitRedisErr)
+	}
+	value, err := cursor(current)
+	if err != nil {
+		return cli.Exit(err, ExitByInput)
+	}
+	if err := h.checkCursor(cctx, value); err != nil {
+		return cli.Exit(fmt.Errorf("cursor %d refused: %w", value, err), ExitLCErr)
+	}
+	if err := h.setCursor(cctx, value); err != nil {
+		return cli.Exit(fmt.Errorf("set cursor failed: %w", err), ExitRedisErr)
+	}
+	log.Warnf("%s cursor changed from %d to %d", h.Name(), current, value)
+	fmt.Printf("%d\n", value)
+	return nil
+}
+
+func _cursorArg(cctx *cli.Context) (uint64, error) {
+	if cctx.NArg() != 1 {
+		return 0, errors.New("one and only one value expected")
+	}
+	return strconv.ParseUint(cctx.Args().First(), 10, 64)
+}
+
+func cursorGet(runnerType string) cli.ActionFunc {
+	return func(cctx *cli.Context) error {
+		return checkerror(_withRunner(cctx, runnerType, func(h cursorHandler) error {
+			cursor, stored, err := h.getCursor(cctx)
+			if err != nil {
+				return cli.Exit(fmt.Errorf("get cursor failed: %w", err), ExitRedisErr)
+			}
+			if !stored {
+				log.Warnf("%s cursor not stored, the default is used", h.Name())
+			}
+			fmt.Printf("%d\n", cursor)
+			return nil
+		}))
+	}
+}
+
+func cursorSet(runnerType string) cli.ActionFunc {
+	return func(cctx *cli.Context) error {
+		value, err := _cursorArg(cctx)
+		if err != nil {
+			return checkerror(cli.Exit(fmt.Errorf("invalid cursor: %w", err), ExitByInput))
+		}
+		return checkerror(_withRunner(cctx, runnerType, func(h cursorHandler) error {
+			return _writeCursor(cctx, h, func(_ uint64) (uint64, error) {
+				return value, nil
+			})
+		}))
+	}
+}
+
+func cursorRewind(runnerType string) cli.ActionFunc {
+	return func(cctx *cli.Context) error {
+		count, err := _cursorArg(cctx)
+		if err != nil {
+			return checkerror(cli.Exit(fmt.Errorf("invalid rewind count: %w", err), ExitByInput))
+		}
+		return checkerror(_withRunner(cctx, runnerType, func(h cursorHandler) error {
+			return _writeCursor(cctx, h, func(current uint64) (uint64, error) {
+				if count > current {
+					return 0, fmt.Errorf("cannot rewind %d from %d", count, current)
+				}
+				return current - count, nil
+			})
+		}))
+	}
+}
+
+// _runnerTypes are the types of runners, each is configured if its contract flag is set
+var _runnerTypes = []struct {
+	name     string
+	flags    []cli.Flag
+	contract string
+}{
+	{"maintain", _maintainFlags, _maintainTargetLCFlag.Name},
+	{"sync", _syncFlags, _syncTargetMCSFlag.Name},
+	{"update", _updateFlags, _updaterTargetLCFlag.Name},
+	{"xmaintain", _xmaintainFlags, _xmaintainTargetLCFlag.Name},
+	{"xsync", _xSyncFlags, _xSyncTargetMCSFlag.Name},
+}
+
+// cursorCommands creates the subcommands of each runner type for the cursor action
+func cursorCommands(usage, argsUsage string, action func(runnerType string) cli.ActionFunc) []*cli.Command {
+	cmds := make([]*cli.Command, 0, len(_runnerTypes))
+	for _, r := range _runnerTypes {
+		cmds = append(cmds, &cli.Command{
+			Name:      r.name,
+			Usage:     fmt.Sprintf(usage, r.name),
+			ArgsUsage: argsUsage,
+			Action:    action(r.name),
+			Flags:     r.flags,
+			Before:    altsrc.InitInputSourceWithContext(r.flags, altsrc.NewYamlSourceFromFlagFunc(_confFileFlag.Name)),
+		})
+	}
+	return cmds
+}
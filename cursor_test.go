@@ -0,0 +1,66 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/urfave/cli/v2"
+)
+
+func TestCheckCursorRange(t *testing.T) {
+	lastHeight := common.Height(3*common.BlocksInEpoch - 1)
+	tests := []struct {
+		cursor common.Height
+		ok     bool
+	}{
+		{lastHeight, false},
+		{lastHeight + 1, true},
+		{lastHeight + common.Height(common.BlocksInEpoch), true},
+		{lastHeight + common.Height(common.BlocksInEpoch) + 1, false},
+	}
+	for _, test := range tests {
+		err := checkCursorRange(test.cursor, lastHeight)
+		if (err == nil) != test.ok {
+			t.Fatalf("cursor:%s with lastHeight:%s want ok:%t, got %v", &test.cursor, &lastHeight, test.ok, err)
+		}
+	}
+}
+
+func TestLooperCursor(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	a := &looper{runner: runner{conf: &Config{TargetName: "CURSOR", SrcStartHeight: 100}, store: store}}
+	a.keys.startHeightKey = "looper_cursor_start_50001"
+	defer cursorGauge.DeleteLabelValues(a._metricLabels()...)
+	cctx := &cli.Context{Context: context.Background()}
+
+	if h, stored, err := a.getCursor(cctx); err != nil || stored || h != 100 {
+		t.Fatalf("default cursor want:100 got:%d %t %v", h, stored, err)
+	}
+	if err := a.setCursor(cctx, 12345); err != nil {
+		t.Fatal(err)
+	}
+	if h, stored, err := a.getCursor(cctx); err != nil || !stored || h != 12345 {
+		t.Fatalf("cursor want:12345 got:%d %t %v", h, stored, err)
+	}
+}
@@ -0,0 +1,278 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	sc "sync"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ThinkiumGroup/go-common/math"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	endpointProbeInterval = 15 * time.Second
+	endpointMaxErrorRate  = 0.5 // endpoints with higher error rate are unhealthy
+	endpointMaxLag        = 5   // endpoints lagging more blocks than this behind the highest head are unhealthy
+	endpointEWMAWeight    = 0.2 // weight of the newest sample in latency and error rate
+)
+
+// ethEndpoint is one of the eth_api addresses of the target chain, with its health statistics
+type ethEndpoint struct {
+	addr     string
+	client   *ethclient.Client
+	verified bool    // whether the chain id has been checked
+	disabled bool    // chain id not match, never use it again
+	latency  float64 // EWMA of request latency in milliseconds
+	errRate  float64 // EWMA of transport errors, in [0, 1]
+	head     uint64  // latest block number
+	lock     sc.RWMutex
+}
+
+func newEthEndpoint(addr string) (*ethEndpoint, error) {
+	c, err := ethclient.Dial(addr)
+	if err != nil || c == nil {
+		return nil, fmt.Errorf("connect TARGET@%s failed: %w", addr, err)
+	}
+	return &ethEndpoint{addr: addr, client: c}, nil
+}
+
+func (e *ethEndpoint) String() string {
+	if e == nil {
+		return "Endpoint<nil>"
+	}
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return fmt.Sprintf("Endpoint{%s Head:%d Latency:%.0fms ErrRate:%.2f Verified:%t Disabled:%t}",
+		e.addr, e.head, e.latency, e.errRate, e.verified, e.disabled)
+}
+
+// record updates statistics with the result of one request, only transport errors are
+// counted, for the errors returned by the JSON-RPC server means the endpoint is alive.
+func (e *ethEndpoint) record(elapsed time.Duration, err error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	failed := 0.0
+	if isTransportError(err) {
+		failed = 1
+	}
+	e.errRate = e.errRate*(1-endpointEWMAWeight) + failed*endpointEWMAWeight
+	ms := float64(elapsed.Milliseconds())
+	if e.latency == 0 {
+		e.latency = ms
+	} else {
+		e.latency = e.latency*(1-endpointEWMAWeight) + ms*endpointEWMAWeight
+	}
+}
+
+func (e *ethEndpoint) setHead(head uint64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.head = head
+}
+
+func (e *ethEndpoint) setVerified(verified, disabled bool) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.verified = verified
+	e.disabled = disabled
+}
+
+func (e *ethEndpoint) stats() endpointStats {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	return endpointStats{
+		verified: e.verified,
+		disabled: e.disabled,
+		latency:  e.latency,
+		errRate:  e.errRate,
+		head:     e.head,
+	}
+}
+
+// chainID checks the chain id of the endpoint, returns nil id if the endpoint is unreachable
+func (e *ethEndpoint) chainID(ctx context.Context) (*big.Int, error) {
+	cctx, cancel := context.WithTimeout(ctx, reqTimeOut*6)
+	defer cancel()
+	start := time.Now()
+	id, err := e.client.ChainID(cctx)
+	e.record(time.Since(start), err)
+	return id, err
+}
+
+func (e *ethEndpoint) probe(ctx context.Context) error {
+	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
+	defer cancel()
+	start := time.Now()
+	head, err := e.client.BlockNumber(cctx)
+	e.record(time.Since(start), err)
+	if err != nil {
+		return err
+	}
+	e.setHead(head)
+	return nil
+}
+
+type endpointStats struct {
+	verified bool
+	disabled bool
+	latency  float64
+	errRate  float64
+	head     uint64
+}
+
+// orderEndpoints returns indexes of the available endpoints. Healthy endpoints come first in the
+// configured order, the unhealthy ones follow with the lower error rate first. Unverified or
+// disabled endpoints are not available.
+func orderEndpoints(stats []endpointStats) []int {
+	var maxHead uint64
+	for _, s := range stats {
+		if s.verified && !s.disabled && s.head > maxHead {
+			maxHead = s.head
+		}
+	}
+	var healthy, unhealthy []int
+	for i, s := range stats {
+		if !s.verified || s.disabled {
+			continue
+		}
+		if s.errRate < endpointMaxErrorRate && (s.head == 0 || maxHead-s.head <= endpointMaxLag) {
+			healthy = append(healthy, i)
+		} else {
+			unhealthy = append(unhealthy, i)
+		}
+	}
+	sort.SliceStable(unhealthy, func(i, j int) bool {
+		return stats[unhealthy[i]].errRate < stats[unhealthy[j]].errRate
+	})
+	return append(healthy, unhealthy...)
+}
+
+// isTransportError returns true if err is not nil and not an error responded by the JSON-RPC
+// server, which means another endpoint should be tried.
+func isTransportError(err error) bool {
+	if err == nil || errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr)
+}
+
+// splitAddrs splits comma separated addresses
+func splitAddrs(s string) []string {
+	var ret []string
+	for _, one := range strings.Split(s, ",") {
+		if one = strings.TrimSpace(one); one != "" {
+			ret = append(ret, one)
+		}
+	}
+	return ret
+}
+
+func (c *EthClient) _available() []*ethEndpoint {
+	stats := make([]endpointStats, len(c.endpoints))
+	for i, ep := range c.endpoints {
+		stats[i] = ep.stats()
+	}
+	idxs := orderEndpoints(stats)
+	ret := make([]*ethEndpoint, 0, len(idxs))
+	for _, i := range idxs {
+		ret = append(ret, c.endpoints[i])
+	}
+	return ret
+}
+
+// _do calls fn with the available endpoints in order, until one of them returns without a
+// transport error.
+func (c *EthClient) _do(ctx context.Context, timeout time.Duration, fn func(ctx context.Context, cl *ethclient.Client) error) error {
+	eps := c._available()
+	if len(eps) == 0 {
+		return errors.New("no available endpoint")
+	}
+	var err error
+	for _, ep := range eps {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err = fn(cctx, ep.client)
+		cancel()
+		ep.record(time.Since(start), err)
+		if !isTransportError(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Warnf("%s failed: %v", ep, err)
+	}
+	return err
+}
+
+// _verify checks chain id of the endpoint, returns false if it is unreachable
+func (c *EthClient) _verify(ctx context.Context, ep *ethEndpoint) (bool, error) {
+	id, err := ep.chainID(ctx)
+	if err != nil {
+		return false, nil
+	}
+	if math.CompareBigInt(id, c.ChainId) != 0 {
+		ep.setVerified(true, true)
+		return true, fmt.Errorf("TARGET@%s chain id not match, want:%s got:%s", ep.addr,
+			math.BigIntForPrint(c.ChainId), math.BigIntForPrint(id))
+	}
+	ep.setVerified(true, false)
+	return true, nil
+}
+
+func (c *EthClient) _probeLoop(ctx context.Context) {
+	ticker := time.NewTicker(endpointProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c._probe(ctx)
+		}
+	}
+}
+
+func (c *EthClient) _probe(ctx context.Context) {
+	for _, ep := range c.endpoints {
+		s := ep.stats()
+		if s.disabled {
+			continue
+		}
+		if !s.verified {
+			if ok, err := c._verify(ctx, ep); err != nil {
+				log.Errorf("%s disabled: %v", ep, err)
+				continue
+			} else if !ok {
+				continue
+			}
+			log.Infof("%s verified", ep)
+		}
+		if err := ep.probe(ctx); err != nil {
+			log.Debugf("probe %s failed: %v", ep, err)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+func TestOrderEndpoints(t *testing.T) {
+	stats := []endpointStats{
+		{verified: true, head: 100, errRate: 0.1},   // healthy
+		{verified: true, head: 90, errRate: 0},      // lagging
+		{verified: false, head: 100},                // not verified
+		{verified: true, head: 101, errRate: 0.9},   // error rate too high
+		{verified: true, head: 101, errRate: 0},     // healthy
+		{verified: true, disabled: true, head: 200}, // chain id not match
+		{verified: true, head: 0, errRate: 0.3},     // head unknown yet
+		{verified: true, head: 100, errRate: 0.6},   // error rate too high
+	}
+	got := orderEndpoints(stats)
+	want := []int{0, 4, 6, 1, 7, 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
+
+func TestTransportError(t *testing.T) {
+	if isTransportError(nil) || isTransportError(ethereum.NotFound) {
+		t.Fatal("nil and NotFound are not transport errors")
+	}
+	if !isTransportError(errors.New("connection refused")) {
+		t.Fatal("should be a transport error")
+	}
+}
+
+func TestSplitAddrs(t *testing.T) {
+	got := splitAddrs(" https://a.io ,https://b.io,, ")
+	want := []string{"https://a.io", "https://b.io"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v, got %v", want, got)
+	}
+}
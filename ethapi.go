@@ -19,6 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"strings"
 	"time"
 
 	"github.com/ThinkiumGroup/go-common"
@@ -39,59 +40,92 @@ const (
 	DistributedLockKeyInContext = "distributed_lock"
 )
 
-var (
-	retryInterval time.Duration = 5
-)
+const defaultRetryInterval = 5 * time.Second
 
 type EthClient struct {
-	Client          *ethclient.Client
+	endpoints       []*ethEndpoint
 	IsTKMChain      bool
 	ChainId         *big.Int
 	SuggestGasPrice *Expirable[*big.Int]
+	Fees            *FeePolicy
+	Replace         *ReplacePolicy
+	RetryInterval   time.Duration // interval of polling the receipts
+	cancel          context.CancelFunc
 }
 
-func NewEthClient(ctx context.Context, addr string, chainid *big.Int, gpttlseconds int64, isTKMChain ...bool) (ec *EthClient, err error) {
-	var c *ethclient.Client
-	c, err = ethclient.Dial(addr)
-	if err != nil || c == nil {
-		return nil, fmt.Errorf("connect TARGET@%s failed: %w", addr, err)
-	}
+// NewEthClient connects to the target chain with the addresses in priority order. All the
+// reachable endpoints must be on the same chain, the unreachable ones will be verified by the
+// background probing before being used.
+func NewEthClient(ctx context.Context, addrs []string, chainid *big.Int, gpttlseconds int64, isTKMChain ...bool) (ec *EthClient, err error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no target address")
+	}
+	var eps []*ethEndpoint
 	defer func() {
 		if err != nil {
-			if c != nil {
-				c.Close()
+			for _, ep := range eps {
+				ep.client.Close()
 			}
 		}
 	}()
+	for _, addr := range addrs {
+		ep, err := newEthEndpoint(addr)
+		if err != nil {
+			return nil, err
+		}
+		eps = append(eps, ep)
+	}
 
-	var id *big.Int
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut*6)
-	defer cancel()
-	id, err = c.ChainID(cctx)
-	if err != nil {
-		return nil, err
+	id := chainid
+	verified := 0
+	for _, ep := range eps {
+		epid, err := ep.chainID(ctx)
+		if err != nil {
+			log.Warnf("TARGET@%s unreachable: %v", ep.addr, err)
+			continue
+		}
+		if id != nil && math.CompareBigInt(id, epid) != 0 {
+			return nil, fmt.Errorf("TARGET@%s chain id not match, want:%s got:%s", ep.addr,
+				math.BigIntForPrint(id), math.BigIntForPrint(epid))
+		}
+		id = epid
+		ep.setVerified(true, false)
+		verified++
+		log.Infof("TARGET@EthClient(%s) ChainID:%s connected", ep.addr, id)
 	}
-	if chainid != nil && math.CompareBigInt(chainid, id) != 0 {
-		return nil, fmt.Errorf("chain id not match, want:%s got:%s", math.BigIntForPrint(chainid), math.BigIntForPrint(id))
+	if verified == 0 {
+		return nil, errors.New("none of the target endpoints is reachable")
 	}
-	log.Infof("TARGET@EthClient(%s) ChainID:%s connected", addr, id)
 	ec = &EthClient{
-		Client:          c,
+		endpoints:       eps,
 		ChainId:         id,
 		SuggestGasPrice: NewExpirable(big.NewInt(0), 1000*gpttlseconds, 0),
+		RetryInterval:   defaultRetryInterval,
 	}
 	if len(isTKMChain) > 0 && isTKMChain[0] {
 		log.Infof("TARGET is an TKM chain")
 		ec.IsTKMChain = true
 	}
+	ec._probe(ctx)
+	if len(eps) > 1 {
+		pctx, cancel := context.WithCancel(context.Background())
+		ec.cancel = cancel
+		go ec._probeLoop(pctx)
+	}
 	return ec, nil
 }
 
 func (c *EthClient) Close() {
-	if c.Client != nil {
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+	if c.endpoints != nil {
 		log.Warnf("%s closing", c)
-		c.Client.Close()
-		c.Client = nil
+		for _, ep := range c.endpoints {
+			ep.client.Close()
+		}
+		c.endpoints = nil
 	}
 }
 
@@ -99,52 +133,78 @@ func (c *EthClient) String() string {
 	if c == nil {
 		return "EthClient<nil>"
 	}
-	return fmt.Sprintf("EthClient{ChainID:%s}", c.ChainId)
+	return fmt.Sprintf("EthClient{ChainID:%s Endpoints:%d}", c.ChainId, len(c.endpoints))
 }
 
 func (c *EthClient) _check() error {
-	if c.Client == nil || c.ChainId == nil {
+	if len(c.endpoints) == 0 || c.ChainId == nil {
 		return errors.New("invalid client")
 	}
 	return nil
 }
 
+// alive checks whether any of the endpoints is available and still on the same chain
+func (c *EthClient) alive(ctx context.Context) error {
+	if err := c._check(); err != nil {
+		return err
+	}
+	var id *big.Int
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		id, err = cl.ChainID(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if math.CompareBigInt(id, c.ChainId) != 0 {
+		return fmt.Errorf("chain id not match, want:%s got:%s", math.BigIntForPrint(c.ChainId), math.BigIntForPrint(id))
+	}
+	return nil
+}
+
 func (c *EthClient) nonceWithBalanceMoreThan(ctx context.Context, addr common.Address, checkBalance bool, levels ...*big.Int) (uint64, error) {
 	if err := c._check(); err != nil {
 		return 0, err
 	}
 	acc := T2E.Address(addr)
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut*2)
-	defer cancel()
-	if checkBalance {
-		balance, err := c.Client.PendingBalanceAt(cctx, acc)
-		if err != nil {
-			return 0, err
-		}
-		var level *big.Int
-		if len(levels) > 0 {
-			level = levels[0]
-		}
-		bl := (*math.BigInt)(level).MustInt()
-		if (*math.BigInt)(balance).CompareInt(bl) <= 0 {
-			return 0, fmt.Errorf("balance of %x is less than %s", addr[:], math.BigIntForPrint(bl))
+	var nonce uint64
+	err := c._do(ctx, reqTimeOut*2, func(ctx context.Context, cl *ethclient.Client) error {
+		if checkBalance {
+			balance, err := cl.PendingBalanceAt(ctx, acc)
+			if err != nil {
+				return err
+			}
+			var level *big.Int
+			if len(levels) > 0 {
+				level = levels[0]
+			}
+			bl := (*math.BigInt)(level).MustInt()
+			if (*math.BigInt)(balance).CompareInt(bl) <= 0 {
+				return fmt.Errorf("balance of %x is less than %s", addr[:], math.BigIntForPrint(bl))
+			}
 		}
-	}
-	return c.Client.PendingNonceAt(cctx, acc)
+		var err error
+		nonce, err = cl.PendingNonceAt(ctx, acc)
+		return err
+	})
+	return nonce, err
 }
 
 func (c *EthClient) nonceAndBalance(ctx context.Context, addr common.Address) (uint64, *big.Int, error) {
 	if err := c._check(); err != nil {
 		return 0, nil, err
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut*2)
-	defer cancel()
 	acc := T2E.Address(addr)
-	nonce, err := c.Client.PendingNonceAt(cctx, acc)
-	if err != nil {
-		return 0, nil, err
-	}
-	balance, err := c.Client.PendingBalanceAt(cctx, acc)
+	var nonce uint64
+	var balance *big.Int
+	err := c._do(ctx, reqTimeOut*2, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		nonce, err = cl.PendingNonceAt(ctx, acc)
+		if err != nil {
+			return err
+		}
+		balance, err = cl.PendingBalanceAt(ctx, acc)
+		return err
+	})
 	if err != nil {
 		return nonce, nil, err
 	}
@@ -155,18 +215,36 @@ func (c *EthClient) getBalance(ctx context.Context, addr common.Address) (*big.I
 	if err := c._check(); err != nil {
 		return nil, err
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
-	return c.Client.PendingBalanceAt(cctx, T2E.Address(addr))
+	var balance *big.Int
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		balance, err = cl.PendingBalanceAt(ctx, T2E.Address(addr))
+		return err
+	})
+	return balance, err
+}
+
+// confirmedNonce returns the nonce of addr at the latest block, without the pending txs
+func (c *EthClient) confirmedNonce(ctx context.Context, addr common.Address) (uint64, error) {
+	if err := c._check(); err != nil {
+		return 0, err
+	}
+	var nonce uint64
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		nonce, err = cl.NonceAt(ctx, T2E.Address(addr), nil)
+		return err
+	})
+	return nonce, err
 }
 
 func (c *EthClient) getNonce(ctx context.Context, addr common.Address) (uint64, error) {
 	if err := c._check(); err != nil {
 		return 0, err
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
-	nonce, err := c.Client.PendingNonceAt(cctx, T2E.Address(addr))
+	var nonce uint64
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		nonce, err = cl.PendingNonceAt(ctx, T2E.Address(addr))
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -177,9 +255,12 @@ func (c *EthClient) getCode(ctx context.Context, addr common.Address) ([]byte, e
 	if err := c._check(); err != nil {
 		return nil, err
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
-	return c.Client.PendingCodeAt(cctx, T2E.Address(addr))
+	var code []byte
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		code, err = cl.PendingCodeAt(ctx, T2E.Address(addr))
+		return err
+	})
+	return code, err
 }
 
 func (c *EthClient) estimateGas(ctx context.Context, from common.Address, to *common.Address, gas uint64,
@@ -195,9 +276,12 @@ func (c *EthClient) estimateGas(ctx context.Context, from common.Address, to *co
 		Value:    value,
 		Data:     data,
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
-	return c.Client.EstimateGas(cctx, msg)
+	var estimated uint64
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		estimated, err = cl.EstimateGas(ctx, msg)
+		return err
+	})
+	return estimated, err
 }
 
 func (c *EthClient) getter(ctx context.Context, from common.Address, to *common.Address,
@@ -232,9 +316,12 @@ func (c *EthClient) callContract(ctx context.Context, from common.Address, to *c
 		Value:    value,
 		Data:     data,
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
-	return c.Client.PendingCallContract(cctx, msg)
+	var output []byte
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		output, err = cl.PendingCallContract(ctx, msg)
+		return err
+	})
+	return output, err
 }
 
 func (c *EthClient) suggestGasPrice(ctx context.Context) (*big.Int, error) {
@@ -242,10 +329,10 @@ func (c *EthClient) suggestGasPrice(ctx context.Context) (*big.Int, error) {
 	if exist {
 		return gp, nil
 	}
-	var err error
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
-	gp, err = c.Client.SuggestGasPrice(cctx)
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		gp, err = cl.SuggestGasPrice(ctx)
+		return err
+	})
 	if err != nil || gp == nil {
 		return gp, err
 	}
@@ -287,24 +374,110 @@ func (c *EthClient) sendLegacyTx(ctx context.Context, priv []byte, to *common.Ad
 	if err != nil {
 		return nil, nil, err
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
-	err = c.Client.SendTransaction(cctx, tx)
+	if err = c.sendTransaction(ctx, tx); err != nil {
+		return tx, nil, err
+	}
+	ethHash := tx.Hash()
+	return tx, common.BytesToHashP(ethHash[:]), nil
+}
+
+func (c *EthClient) sendDynamicFeeTx(ctx context.Context, priv []byte, to *common.Address, nonce uint64, gas uint64,
+	feeCap, tipCap *big.Int, value *big.Int, input []byte) (*types.Transaction, *common.Hash, error) {
+	if err := c._check(); err != nil {
+		return nil, nil, err
+	}
+	pk, err := crypto.ToECDSA(priv)
 	if err != nil {
+		return nil, nil, fmt.Errorf("private key error: %w", err)
+	}
+	if feeCap == nil || tipCap == nil {
+		fc, tc, err := c.suggestDynamicFees(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("suggest fees failed: %w", err)
+		}
+		if feeCap == nil {
+			feeCap = fc
+		}
+		if tipCap == nil {
+			tipCap = tc
+		}
+	}
+	if value == nil {
+		value = big.NewInt(0)
+	}
+	log.Debugf("trying to send: {Nonce:%d FeeCap:%s TipCap:%s Gas:%d To:%x Val:%s len(Data):%d}",
+		nonce, math.BigIntForPrint(feeCap), math.BigIntForPrint(tipCap), gas, common.ForPrint(to, 0),
+		math.BigIntForPrint(value), len(input))
+	signer := types.LatestSignerForChainID(c.ChainId)
+	tx, err := types.SignNewTx(pk, signer, &types.DynamicFeeTx{
+		ChainID:   c.ChainId,
+		Nonce:     nonce,
+		GasTipCap: tipCap,
+		GasFeeCap: feeCap,
+		Gas:       gas,
+		To:        T2E.AddressP(to),
+		Value:     value,
+		Data:      input,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = c.sendTransaction(ctx, tx); err != nil {
 		return tx, nil, err
 	}
 	ethHash := tx.Hash()
 	return tx, common.BytesToHashP(ethHash[:]), nil
 }
 
+// sendTx sends a new tx with the type and fees decided by the fee policy of the client
+func (c *EthClient) sendTx(ctx context.Context, priv []byte, to *common.Address, nonce uint64, gas uint64,
+	value *big.Int, input []byte) (*types.Transaction, *common.Hash, error) {
+	if c._isDynamic() {
+		return c.sendDynamicFeeTx(ctx, priv, to, nonce, gas, nil, nil, value, input)
+	}
+	gasPrice, err := c.maxGasPrice(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("suggest gas price failed: %w", err)
+	}
+	return c.sendLegacyTx(ctx, priv, to, nonce, gas, gasPrice, value, input)
+}
+
+// sendTransaction broadcasts the signed tx. It is safe to be sent to another endpoint again if
+// the previous one failed in transport.
+// If there's a journal in ctx, the tx will be journaled before sending.
+// If there's a DistributedLock in ctx, it will be validated before sending, and the tx will not
+// be sent if it's not held by current process any more.
+func (c *EthClient) sendTransaction(ctx context.Context, tx *types.Transaction) error {
+	if lock := getDistributedLock(ctx); lock != nil {
+		if err := lock.Validate(ctx); err != nil {
+			return fmt.Errorf("tx Nonce:%d aborted: %w", tx.Nonce(), err)
+		}
+	}
+	if journal, ref := getTxJournal(ctx); journal != nil {
+		if err := journal.add(ctx, tx, ref); err != nil {
+			return err
+		}
+	}
+	return c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) error {
+		err := cl.SendTransaction(ctx, tx)
+		if err != nil && strings.Contains(err.Error(), "already known") {
+			// sent by the previous endpoint
+			return nil
+		}
+		return err
+	})
+}
+
 func (c *EthClient) getReceipt(ctx context.Context, txHash common2.Hash) (*types.Receipt, error) {
 	if err := c._check(); err != nil {
 		return nil, err
 	}
-	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
-	defer cancel()
 	log.Debugf("try get receipt of txHash: %x", txHash[:])
-	ethrecept, err := c.Client.TransactionReceipt(cctx, txHash)
+	var ethrecept *types.Receipt
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		ethrecept, err = cl.TransactionReceipt(ctx, txHash)
+		return err
+	})
 	return ethrecept, err
 }
 
@@ -330,27 +503,24 @@ func getDistributedLock(ctx context.Context) DistributedLock {
 	return l
 }
 
-func (c *EthClient) checkReceipt(ctx context.Context, tx *types.Transaction) (*client.ReceiptWithFwds, error) {
-	lock := getDistributedLock(ctx)
-	txHash := tx.Hash()
+// checkReceipt waits for the receipt of tx. If priv is provided, the tx would be replaced according
+// to the ReplacePolicy of the client when it's stuck, and the receipt of whichever mined returns.
+func (c *EthClient) checkReceipt(ctx context.Context, priv []byte, tx *types.Transaction) (*client.ReceiptWithFwds, error) {
+	tracked := newTrackedTx(tx)
 
-	for i := 0; i < 5; i++ {
-		if lock != nil {
-			if err := lock.Refresh(ctx); err != nil {
-				log.Warnf("refresh %s failed: %v", lock, err)
-			}
-		}
+	for i := 0; i < c.Replace.rounds(5, c.RetryInterval); i++ {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
-		default:
-			time.Sleep(retryInterval * time.Second)
-			rec, err := c.getReceipt(ctx, txHash)
-			if err != nil || rec == nil {
+		case <-time.After(c.RetryInterval):
+			minedTx, rec := c._receiptOf(ctx, tracked)
+			if rec == nil {
+				c._replaceIfStuck(ctx, priv, tracked)
 				continue
 			}
+			c._settled(ctx, tracked)
 
-			txrpt, err := E2T.TxReceipt(tx, rec, c.IsTKMChain)
+			txrpt, err := E2T.TxReceipt(minedTx, rec, c.IsTKMChain)
 			if err != nil {
 				return nil, err
 			}
@@ -360,12 +530,14 @@ func (c *EthClient) checkReceipt(ctx context.Context, tx *types.Transaction) (*c
 	return nil, client.ErrNoReceipt
 }
 
-func (c *EthClient) checkReceipts(ctx context.Context, ethtxs ...*types.Transaction) ([]*client.ReceiptWithFwds, error) {
+// checkReceipts waits for the receipts of ethtxs, and returns them in the same order. The nil
+// ones are not received. Stuck txs are replaced as in checkReceipt.
+func (c *EthClient) checkReceipts(ctx context.Context, priv []byte, ethtxs ...*types.Transaction) ([]*client.ReceiptWithFwds, error) {
 	if len(ethtxs) == 0 {
 		return nil, nil
 	}
 	txHashList := make([]common.Hash, 0, len(ethtxs))
-	txMap := make(map[common.Hash]*types.Transaction)
+	txMap := make(map[common.Hash]*trackedTx)
 	rptMap := make(map[common.Hash]*client.ReceiptWithFwds)
 	for _, ethtx := range ethtxs {
 		if ethtx == nil {
@@ -373,31 +545,26 @@ func (c *EthClient) checkReceipts(ctx context.Context, ethtxs ...*types.Transact
 		}
 		txhash := E2T.Hash(ethtx.Hash())
 		txHashList = append(txHashList, txhash)
-		txMap[txhash] = ethtx
+		txMap[txhash] = newTrackedTx(ethtx)
 	}
 
-	lock := getDistributedLock(ctx)
-	for i := 0; i < 12; i++ {
-		if lock != nil {
-			if err := lock.Refresh(ctx); err != nil {
-				log.Warnf("refresh %s failed: %v", lock, err)
-			}
-		}
+	for i := 0; i < c.Replace.rounds(12, c.RetryInterval); i++ {
 		select {
 		case <-ctx.Done():
 			return nil, ctx.Err()
-		default:
-			time.Sleep(retryInterval * time.Second)
+		case <-time.After(c.RetryInterval):
 			for _, txhash := range txHashList {
 				if _, exist := rptMap[txhash]; exist {
 					continue
 				}
-				rec, err := c.getReceipt(ctx, T2E.Hash(txhash))
-				if err != nil || rec == nil {
+				tracked := txMap[txhash]
+				ethtx, rec := c._receiptOf(ctx, tracked)
+				if rec == nil {
+					c._replaceIfStuck(ctx, priv, tracked)
 					continue
 				}
+				c._settled(ctx, tracked)
 				// log.Debugf("receipt of 0x%x: %+v", txhash[:], rec)
-				ethtx := txMap[txhash]
 				txrpt, err := E2T.TxReceipt(ethtx, rec, c.IsTKMChain)
 				if err != nil {
 					continue
@@ -0,0 +1,197 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ThinkiumGroup/go-common/math"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+const (
+	TxTypeLegacy  = "legacy"
+	TxTypeDynamic = "dynamic"
+	TxTypeAuto    = "auto"
+
+	feeHistoryBlocks     = 10   // number of blocks used to estimate the priority fee
+	feeHistoryPercentile = 50.0 // percentile of priority fees paid in each block
+	baseFeeMultiplier    = 2    // fee cap = baseFeeMultiplier * next base fee + tip, to survive several full blocks
+)
+
+var (
+	gweiUnit = big.NewInt(1e9)
+)
+
+// FeePolicy decides which kind of transaction to send to the target chain, and the upper limits of
+// the fees (nil for unlimited).
+type FeePolicy struct {
+	TxType    string
+	MaxFeeCap *big.Int // max fee per gas of dynamic-fee tx, also the max gas price of legacy tx
+	MaxTipCap *big.Int // max priority fee per gas of dynamic-fee tx
+}
+
+func (p *FeePolicy) String() string {
+	if p == nil {
+		return "FeePolicy<nil>"
+	}
+	return fmt.Sprintf("FeePolicy{%s MaxFeeCap:%s MaxTipCap:%s}", p.TxType,
+		math.BigIntForPrint(p.MaxFeeCap), math.BigIntForPrint(p.MaxTipCap))
+}
+
+func parseTxType(s string) (string, error) {
+	switch t := strings.ToLower(strings.TrimSpace(s)); t {
+	case "", TxTypeLegacy:
+		return TxTypeLegacy, nil
+	case TxTypeDynamic, "1559", "eip1559":
+		return TxTypeDynamic, nil
+	case TxTypeAuto:
+		return TxTypeAuto, nil
+	default:
+		return "", fmt.Errorf("unknown tx type: %s", s)
+	}
+}
+
+// gweiToWei converts a gwei value to wei, returns nil if gwei<=0
+func gweiToWei(gwei float64) *big.Int {
+	if gwei <= 0 {
+		return nil
+	}
+	f := new(big.Float).Mul(big.NewFloat(gwei), new(big.Float).SetInt(gweiUnit))
+	wei, _ := f.Int(nil)
+	return wei
+}
+
+// dynamicFees calculates the fee cap and tip cap from the fee history. The tip is the median of the
+// priority fees rewarded in the recent blocks, and the fee cap leaves room for the base fee to
+// rise before the tx is packed. Both of them are limited by the policy.
+func dynamicFees(history *ethereum.FeeHistory, maxFeeCap, maxTipCap *big.Int) (feeCap, tipCap *big.Int, err error) {
+	if history == nil || len(history.BaseFee) == 0 {
+		return nil, nil, errors.New("no base fee in fee history")
+	}
+	// the last one is the base fee of the next block
+	baseFee := history.BaseFee[len(history.BaseFee)-1]
+	if baseFee == nil {
+		return nil, nil, errors.New("nil base fee in fee history")
+	}
+	var tips []*big.Int
+	for _, rewards := range history.Reward {
+		if len(rewards) > 0 && rewards[0] != nil {
+			tips = append(tips, rewards[0])
+		}
+	}
+	tipCap = big.NewInt(0)
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		tipCap = new(big.Int).Set(tips[len(tips)/2])
+	}
+	if maxTipCap != nil && tipCap.Cmp(maxTipCap) > 0 {
+		tipCap = new(big.Int).Set(maxTipCap)
+	}
+	feeCap = new(big.Int).Mul(baseFee, big.NewInt(baseFeeMultiplier))
+	feeCap.Add(feeCap, tipCap)
+	if maxFeeCap != nil && feeCap.Cmp(maxFeeCap) > 0 {
+		if maxFeeCap.Cmp(baseFee) < 0 {
+			return nil, nil, fmt.Errorf("base fee %s exceeds the max fee cap %s",
+				math.BigIntForPrint(baseFee), math.BigIntForPrint(maxFeeCap))
+		}
+		feeCap = new(big.Int).Set(maxFeeCap)
+	}
+	if tipCap.Cmp(feeCap) > 0 {
+		tipCap = new(big.Int).Set(feeCap)
+	}
+	return feeCap, tipCap, nil
+}
+
+// SetFeePolicy sets the fee policy of the client, and detects the tx type by the BaseFee of the
+// latest header if policy.TxType is auto.
+func (c *EthClient) SetFeePolicy(ctx context.Context, policy *FeePolicy) error {
+	if policy == nil {
+		policy = &FeePolicy{TxType: TxTypeLegacy}
+	}
+	if policy.TxType == TxTypeAuto {
+		var london bool
+		err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) error {
+			header, err := cl.HeaderByNumber(ctx, nil)
+			if err != nil {
+				return err
+			}
+			london = header.BaseFee != nil
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("detect tx type failed: %w", err)
+		}
+		p := *policy
+		if london {
+			p.TxType = TxTypeDynamic
+		} else {
+			p.TxType = TxTypeLegacy
+		}
+		log.Infof("%s tx type detected: %s", c, p.TxType)
+		policy = &p
+	}
+	c.Fees = policy
+	return nil
+}
+
+func (c *EthClient) _isDynamic() bool {
+	return c.Fees != nil && c.Fees.TxType == TxTypeDynamic
+}
+
+// suggestDynamicFees returns the fee cap and tip cap for a new dynamic-fee tx
+func (c *EthClient) suggestDynamicFees(ctx context.Context) (feeCap, tipCap *big.Int, err error) {
+	var history *ethereum.FeeHistory
+	err = c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) (err error) {
+		history, err = cl.FeeHistory(ctx, feeHistoryBlocks, nil, []float64{feeHistoryPercentile})
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("fee history failed: %w", err)
+	}
+	var maxFeeCap, maxTipCap *big.Int
+	if c.Fees != nil {
+		maxFeeCap, maxTipCap = c.Fees.MaxFeeCap, c.Fees.MaxTipCap
+	}
+	feeCap, tipCap, err = dynamicFees(history, maxFeeCap, maxTipCap)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Debugf("suggest FeeCap=%s TipCap=%s", math.BigForPrint(feeCap), math.BigForPrint(tipCap))
+	return feeCap, tipCap, nil
+}
+
+// maxGasPrice returns the highest price per gas would be paid by a new tx
+func (c *EthClient) maxGasPrice(ctx context.Context) (*big.Int, error) {
+	if c._isDynamic() {
+		feeCap, _, err := c.suggestDynamicFees(ctx)
+		return feeCap, err
+	}
+	gp, err := c.suggestGasPrice(ctx)
+	if err != nil || gp == nil {
+		return gp, err
+	}
+	if c.Fees != nil && c.Fees.MaxFeeCap != nil && gp.Cmp(c.Fees.MaxFeeCap) > 0 {
+		return new(big.Int).Set(c.Fees.MaxFeeCap), nil
+	}
+	return gp, nil
+}
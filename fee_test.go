@@ -0,0 +1,77 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+func TestDynamicFees(t *testing.T) {
+	history := &ethereum.FeeHistory{
+		Reward: [][]*big.Int{
+			{big.NewInt(3)}, {big.NewInt(1)}, {big.NewInt(2)}, {}, {big.NewInt(5)},
+		},
+		BaseFee: []*big.Int{big.NewInt(90), big.NewInt(95), big.NewInt(100)},
+	}
+	tests := []struct {
+		maxFee, maxTip *big.Int
+		fee, tip       int64
+		err            bool
+	}{
+		{fee: 203, tip: 3},
+		{maxTip: big.NewInt(2), fee: 202, tip: 2},
+		{maxFee: big.NewInt(150), fee: 150, tip: 3},
+		{maxFee: big.NewInt(101), maxTip: big.NewInt(1), fee: 101, tip: 1},
+		{maxFee: big.NewInt(99), err: true},
+	}
+	for i, test := range tests {
+		fee, tip, err := dynamicFees(history, test.maxFee, test.maxTip)
+		if test.err {
+			if err == nil {
+				t.Fatalf("#%d should fail", i)
+			}
+			t.Logf("#%d %v", i, err)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d failed: %v", i, err)
+		}
+		if fee.Int64() != test.fee || tip.Int64() != test.tip {
+			t.Fatalf("#%d want:(%d,%d) got:(%s,%s)", i, test.fee, test.tip, fee, tip)
+		}
+	}
+
+	if _, _, err := dynamicFees(&ethereum.FeeHistory{}, nil, nil); err == nil {
+		t.Fatal("should fail without base fee")
+	}
+}
+
+func TestParseTxType(t *testing.T) {
+	for s, want := range map[string]string{"": TxTypeLegacy, "Legacy": TxTypeLegacy, "1559": TxTypeDynamic,
+		"dynamic": TxTypeDynamic, " auto ": TxTypeAuto} {
+		if got, err := parseTxType(s); err != nil || got != want {
+			t.Fatalf("%q want:%s got:%s err:%v", s, want, got, err)
+		}
+	}
+	if _, err := parseTxType("blob"); err == nil {
+		t.Fatal("should fail")
+	}
+	if gweiToWei(0) != nil || gweiToWei(1.5).Int64() != 1500000000 {
+		t.Fatal("gwei to wei failed")
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	sc "sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	fileValuePrefix = "v:"
+	fileMapPrefix   = "h:"
+	fileLockPrefix  = "l:"
+)
+
+// fileLease is a lock saved in the file store
+type fileLease struct {
+	Value   string `json:"value"`
+	Expires int64  `json:"expires"` // time.UnixMilli()
+}
+
+func (l *fileLease) expired(now time.Time) bool {
+	return l.Expires <= now.UnixMilli()
+}
+
+// fileStore is a StateStore in a local leveldb, for the deployments with only one node. The
+// database can only be opened by one process at a time, so the locks are only effective between
+// the runners in the same process.
+type fileStore struct {
+	path string
+	db   *leveldb.DB
+	lock sc.Mutex // for read-modify-write of locks and counters
+	now  func() time.Time
+}
+
+func newFileStore(path string) (*fileStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("open file store at %s failed: %w", path, err)
+	}
+	return &fileStore{path: path, db: db, now: time.Now}, nil
+}
+
+func (s *fileStore) String() string {
+	return fmt.Sprintf("FileStore{%s}", s.path)
+}
+
+func (s *fileStore) _get(key string) (string, bool, error) {
+	bs, err := s.db.Get([]byte(key), nil)
+	switch {
+	case errors.Is(err, leveldb.ErrNotFound):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	default:
+		return string(bs), true, nil
+	}
+}
+
+func (s *fileStore) Cursor(ctx context.Context, key string) (uint64, bool, error) {
+	value, exist, err := s.Get(ctx, key)
+	if err != nil || !exist {
+		return 0, exist, err
+	}
+	h, err := parseCursor(value)
+	return h, true, err
+}
+
+func (s *fileStore) SetCursor(ctx context.Context, key string, value uint64) error {
+	return s.Set(ctx, key, strconv.FormatUint(value, 10))
+}
+
+func (s *fileStore) Get(_ context.Context, key string) (string, bool, error) {
+	return s._get(fileValuePrefix + key)
+}
+
+func (s *fileStore) Set(_ context.Context, key string, value string) error {
+	return s.db.Put([]byte(fileValuePrefix+key), []byte(value), nil)
+}
+
+func (s *fileStore) Del(_ context.Context, keys ...string) error {
+	batch := new(leveldb.Batch)
+	for _, key := range keys {
+		batch.Delete([]byte(fileValuePrefix + key))
+		iter := s.db.NewIterator(util.BytesPrefix(s._mapPrefix(key)), nil)
+		for iter.Next() {
+			batch.Delete(append([]byte(nil), iter.Key()...))
+		}
+		iter.Release()
+		if err := iter.Error(); err != nil {
+			return err
+		}
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *fileStore) Incr(_ context.Context, key string) (uint64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	value, exist, err := s._get(fileValuePrefix + key)
+	if err != nil {
+		return 0, err
+	}
+	var count uint64
+	if exist {
+		if count, err = parseCursor(value); err != nil {
+			return 0, err
+		}
+	}
+	count++
+	if err = s.db.Put([]byte(fileValuePrefix+key), []byte(strconv.FormatUint(count, 10)), nil); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *fileStore) _mapPrefix(key string) []byte {
+	return []byte(fileMapPrefix + key + "\x00")
+}
+
+func (s *fileStore) HSet(_ context.Context, key, field, value string) error {
+	return s.db.Put(append(s._mapPrefix(key), field...), []byte(value), nil)
+}
+
+func (s *fileStore) HGet(_ context.Context, key, field string) (string, bool, error) {
+	return s._get(string(append(s._mapPrefix(key), field...)))
+}
+
+func (s *fileStore) HDel(_ context.Context, key string, fields ...string) error {
+	batch := new(leveldb.Batch)
+	for _, field := range fields {
+		batch.Delete(append(s._mapPrefix(key), field...))
+	}
+	return s.db.Write(batch, nil)
+}
+
+func (s *fileStore) HGetAll(_ context.Context, key string) (map[string]string, error) {
+	prefix := s._mapPrefix(key)
+	iter := s.db.NewIterator(util.BytesPrefix(prefix), nil)
+	defer iter.Release()
+	all := make(map[string]string)
+	for iter.Next() {
+		all[string(iter.Key()[len(prefix):])] = string(iter.Value())
+	}
+	return all, iter.Error()
+}
+
+// _lease returns the lease of the lock at key, nil if it is free or expired
+func (s *fileStore) _lease(key string) (*fileLease, error) {
+	value, exist, err := s._get(fileLockPrefix + key)
+	if err != nil || !exist {
+		return nil, err
+	}
+	lease := new(fileLease)
+	if err = json.Unmarshal([]byte(value), lease); err != nil {
+		return nil, fmt.Errorf("invalid lease of %s: %w", key, err)
+	}
+	if lease.expired(s.now()) {
+		return nil, nil
+	}
+	return lease, nil
+}
+
+func (s *fileStore) _putLease(key, value string, ttl time.Duration) error {
+	bs, err := json.Marshal(&fileLease{Value: value, Expires: s.now().Add(ttl).UnixMilli()})
+	if err != nil {
+		return err
+	}
+	return s.db.Put([]byte(fileLockPrefix+key), bs, nil)
+}
+
+func (s *fileStore) ObtainLock(_ context.Context, key, value string, ttl time.Duration) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lease, err := s._lease(key)
+	if err != nil {
+		return "", err
+	}
+	if lease != nil && lease.Value != value {
+		return lease.Value, nil
+	}
+	if err = s._putLease(key, value, ttl); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func (s *fileStore) RefreshLock(_ context.Context, key, value string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lease, err := s._lease(key)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Value != value {
+		return ErrLockNotHeld
+	}
+	return s._putLease(key, value, ttl)
+}
+
+func (s *fileStore) ReleaseLock(_ context.Context, key, value string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lease, err := s._lease(key)
+	if err != nil {
+		return err
+	}
+	if lease == nil || lease.Value != value {
+		return ErrLockNotHeld
+	}
+	return s.db.Delete([]byte(fileLockPrefix+key), nil)
+}
+
+func (s *fileStore) LockHolder(_ context.Context, key string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lease, err := s._lease(key)
+	if err != nil || lease == nil {
+		return "", err
+	}
+	return lease.Value, nil
+}
+
+func (s *fileStore) LockTTL(_ context.Context, key string) (time.Duration, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lease, err := s._lease(key)
+	if err != nil || lease == nil {
+		return 0, err
+	}
+	return time.UnixMilli(lease.Expires).Sub(s.now()), nil
+}
+
+func (s *fileStore) Ping(_ context.Context) error {
+	_, err := s.db.GetProperty("leveldb.num-files-at-level0")
+	return err
+}
+
+func (s *fileStore) Describe(_ context.Context) string {
+	return s.String()
+}
+
+func (s *fileStore) Close() error {
+	return s.db.Close()
+}
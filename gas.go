@@ -0,0 +1,147 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// GasPolicy limits the gas of the txs sent to the target chain. The estimated gas is multiplied
+// by Multiplier for safety, and then limited to [Floor, Ceiling].
+type GasPolicy struct {
+	Multiplier float64
+	Floor      uint64
+	Ceiling    uint64
+}
+
+func (p *GasPolicy) String() string {
+	if p == nil {
+		return "GasPolicy<nil>"
+	}
+	return fmt.Sprintf("GasPolicy{x%.2f [%d, %d]}", p.Multiplier, p.Floor, p.Ceiling)
+}
+
+// apply returns the gas limit of a tx with estimated gas
+func (p *GasPolicy) apply(estimated uint64) (uint64, error) {
+	if p == nil {
+		return estimated, nil
+	}
+	if p.Ceiling > 0 && estimated > p.Ceiling {
+		return 0, fmt.Errorf("estimated gas %d exceeds the ceiling %d", estimated, p.Ceiling)
+	}
+	gas := estimated
+	if p.Multiplier > 1 {
+		gas = uint64(float64(estimated) * p.Multiplier)
+	}
+	if gas < p.Floor {
+		gas = p.Floor
+	}
+	if p.Ceiling > 0 && gas > p.Ceiling {
+		gas = p.Ceiling
+	}
+	return gas, nil
+}
+
+// RevertError is returned when the tx is sure to be reverted
+type RevertError struct {
+	Reason string
+	Data   []byte
+	err    error
+}
+
+func (e *RevertError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("execution reverted: %s", e.Reason)
+	}
+	if len(e.Data) > 0 {
+		return fmt.Sprintf("execution reverted: 0x%x", e.Data)
+	}
+	return e.err.Error()
+}
+
+func (e *RevertError) Unwrap() error {
+	return e.err
+}
+
+// asRevertError returns a RevertError if err is a reverted execution responded by the node
+func asRevertError(err error) (*RevertError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	var rerr *RevertError
+	if errors.As(err, &rerr) {
+		return rerr, true
+	}
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return nil, false
+	}
+	ret := &RevertError{err: err}
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if s, ok := dataErr.ErrorData().(string); ok {
+			if data, err := hexutil.Decode(s); err == nil {
+				ret.Data = data
+				ret.Reason = decodeRevert(data, revertABIs()...)
+			}
+		}
+	}
+	if len(ret.Data) == 0 && !strings.Contains(strings.ToLower(rpcErr.Error()), "revert") {
+		return nil, false
+	}
+	return ret, true
+}
+
+// _targetEstimateGas estimates the gas of calling to with input by the sender, and applies the
+// gas policy. A RevertError returns if the call would be reverted. If the estimation failed for
+// other reasons, the ceiling of the policy is used.
+func (a *runner) _targetEstimateGas(ctx context.Context, to *common.Address, input []byte) (uint64, error) {
+	estimated, err := a.target.estimateGas(ctx, a.targetPriv.Address(), to, 0, nil, nil, input)
+	if err != nil {
+		if rerr, ok := asRevertError(err); ok {
+			return 0, rerr
+		}
+		if a.conf.TargetGas == nil || a.conf.TargetGas.Ceiling == 0 {
+			return 0, fmt.Errorf("estimate gas failed: %w", err)
+		}
+		log.Warnf("estimate gas failed, use the ceiling %d: %v", a.conf.TargetGas.Ceiling, err)
+		return a.conf.TargetGas.Ceiling, nil
+	}
+	gas, err := a.conf.TargetGas.apply(estimated)
+	if err != nil {
+		return 0, err
+	}
+	log.Debugf("gas estimated: %d, limit: %d", estimated, gas)
+	return gas, nil
+}
+
+// _targetSuggestBalance returns the balance must be held by the sender for sending txs with the
+// total gas
+func (a *runner) _targetSuggestBalance(ctx context.Context, gas uint64) (mustHave *big.Int) {
+	gasprice, err := a.target.maxGasPrice(ctx)
+	if err != nil || gasprice == nil {
+		return nil
+	}
+	return new(big.Int).Mul(new(big.Int).SetUint64(gas), gasprice)
+}
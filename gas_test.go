@@ -0,0 +1,76 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type testRPCError struct {
+	msg  string
+	data interface{}
+}
+
+func (e *testRPCError) Error() string          { return e.msg }
+func (e *testRPCError) ErrorCode() int         { return 3 }
+func (e *testRPCError) ErrorData() interface{} { return e.data }
+
+func TestGasPolicy(t *testing.T) {
+	p := &GasPolicy{Multiplier: 1.5, Floor: 50000, Ceiling: 1000000}
+	tests := []struct {
+		estimated, want uint64
+		err             bool
+	}{
+		{estimated: 21000, want: 50000},
+		{estimated: 100000, want: 150000},
+		{estimated: 900000, want: 1000000},
+		{estimated: 1000001, err: true},
+	}
+	for i, test := range tests {
+		gas, err := p.apply(test.estimated)
+		if (err != nil) != test.err {
+			t.Fatalf("#%d want error:%t got:%v", i, test.err, err)
+		}
+		if err == nil && gas != test.want {
+			t.Fatalf("#%d want:%d got:%d", i, test.want, gas)
+		}
+	}
+}
+
+func TestRevertError(t *testing.T) {
+	// Error("not enough")
+	data := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"000000000000000000000000000000000000000000000000000000000000000a" +
+		"6e6f7420656e6f75676800000000000000000000000000000000000000000000"
+	err := fmt.Errorf("estimate: %w", &testRPCError{msg: "execution reverted", data: data})
+	rerr, ok := asRevertError(err)
+	if !ok || rerr.Reason != "not enough" {
+		t.Fatalf("revert not decoded: %v", rerr)
+	}
+	t.Log(rerr)
+
+	if rerr, ok := asRevertError(&testRPCError{msg: "execution reverted"}); !ok || rerr.Reason != "" {
+		t.Fatalf("revert without data not recognized: %v", rerr)
+	}
+	if _, ok := asRevertError(&testRPCError{msg: "insufficient funds for gas * price + value"}); ok {
+		t.Fatal("not a revert")
+	}
+	if _, ok := asRevertError(errors.New("connection refused")); ok {
+		t.Fatal("transport error is not a revert")
+	}
+}
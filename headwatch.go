@@ -0,0 +1,81 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+)
+
+// headWatcher notifies once the current height of the source chain moves. The TKM RPC has no
+// streaming or long-poll method for new blocks, so the head is watched by polling the chain
+// stats, which is much lighter than GetBlocks, at a short interval. The fetch interval of the
+// looper is kept as the fallback.
+type headWatcher struct {
+	src      *sourcePool
+	interval time.Duration
+	last     common.Height
+	heads    chan struct{}
+}
+
+func newHeadWatcher(src *sourcePool, interval time.Duration) *headWatcher {
+	return &headWatcher{
+		src:      src,
+		interval: interval,
+		last:     common.NilHeight,
+		heads:    make(chan struct{}, 1),
+	}
+}
+
+// watch polls the head until ctx is done, and returns the channel of the notifications. The
+// notifications not received yet are merged into one.
+func (w *headWatcher) watch(ctx context.Context) <-chan struct{} {
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w._check(ctx)
+			}
+		}
+	}()
+	return w.heads
+}
+
+func (w *headWatcher) _check(ctx context.Context) {
+	stats, err := w.src.ChainStats(ctx)
+	if err != nil || stats == nil {
+		log.Debugf("watch head of %s failed: %v", w.src, err)
+		return
+	}
+	current := common.Height(stats.CurrentHeight)
+	if !w.last.IsNil() && current.Compare(w.last) <= 0 {
+		return
+	}
+	moved := !w.last.IsNil()
+	w.last = current
+	if moved {
+		select {
+		case w.heads <- struct{}{}:
+		default:
+		}
+	}
+}
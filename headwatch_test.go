@@ -0,0 +1,58 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHeadWatcher(t *testing.T) {
+	node := &stubNode{}
+	node.chainId.Store(50001)
+	node.height.Store(100)
+	src, err := newSourcePool([]string{startStubNode(t, node)}, 50001, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := src.connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	heads := newHeadWatcher(src, 10*time.Millisecond).watch(ctx)
+	select {
+	case <-heads:
+		t.Fatal("head not moved")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	node.height.Store(101)
+	select {
+	case <-heads:
+	case <-time.After(2 * time.Second):
+		t.Fatal("new head not notified")
+	}
+	select {
+	case <-heads:
+		t.Fatal("head notified twice")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	sc "sync"
+
+	"github.com/ThinkiumGroup/go-common/log"
+)
+
+const (
+	roleActive  = "active"
+	rolePassive = "passive"
+)
+
+type healthReporter interface {
+	health(ctx context.Context) *healthReport
+}
+
+type connStatus struct {
+	Alive bool   `json:"alive"`
+	Error string `json:"error,omitempty"`
+}
+
+func newConnStatus(err error) *connStatus {
+	if err != nil {
+		return &connStatus{Alive: false, Error: err.Error()}
+	}
+	return &connStatus{Alive: true}
+}
+
+type lockStatus struct {
+	Key    string `json:"key"`
+	Self   string `json:"self"`
+	Holder string `json:"holder"`
+	Error  string `json:"error,omitempty"`
+}
+
+// healthReport is the state of a runner seen by /healthz and /readyz
+type healthReport struct {
+	Runner       string      `json:"runner"`
+	Started      bool        `json:"started"`
+	Role         string      `json:"role"`
+	Source       *connStatus `json:"source,omitempty"`
+	Target       *connStatus `json:"target,omitempty"`
+	Lock         *lockStatus `json:"lock,omitempty"`
+	StartHeight  *uint64     `json:"startHeight,omitempty"`
+	SourceHeight *uint64     `json:"sourceHeight,omitempty"`
+	Lag          *uint64     `json:"lag,omitempty"`
+}
+
+// ready returns true if all the connections of the runner are available. A passive runner
+// (not holding the running lock) is ready as well, it is a standby of the active one.
+func (r *healthReport) ready() bool {
+	if !r.Started {
+		return false
+	}
+	if (r.Source != nil && !r.Source.Alive) || (r.Target != nil && !r.Target.Alive) {
+		return false
+	}
+	if r.Lock != nil && len(r.Lock.Error) > 0 {
+		return false
+	}
+	return true
+}
+
+type healthResponse struct {
+	Status  string          `json:"status"`
+	Runners []*healthReport `json:"runners"`
+}
+
+// healthRegistry holds the runners reported by the health endpoints of the process
+type healthRegistry struct {
+	lock      sc.RWMutex
+	reporters map[string]healthReporter
+}
+
+var runnerHealth = &healthRegistry{reporters: make(map[string]healthReporter)}
+
+func (h *healthRegistry) register(name string, reporter healthReporter) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.reporters[name] = reporter
+}
+
+func (h *healthRegistry) unregister(name string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.reporters, name)
+}
+
+func (h *healthRegistry) reports(ctx context.Context) []*healthReport {
+	h.lock.RLock()
+	names := make([]string, 0, len(h.reporters))
+	for name := range h.reporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reporters := make([]healthReporter, 0, len(names))
+	for _, name := range names {
+		reporters = append(reporters, h.reporters[name])
+	}
+	h.lock.RUnlock()
+
+	reports := make([]*healthReport, 0, len(reporters))
+	for _, reporter := range reporters {
+		reports = append(reports, reporter.health(ctx))
+	}
+	return reports
+}
+
+// handler writes the reports of all runners, with 503 if any of them is not ok
+func (h *healthRegistry) handler(ok func(r *healthReport) bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		resp := &healthResponse{Status: "ok", Runners: h.reports(req.Context())}
+		code := http.StatusOK
+		if len(resp.Runners) == 0 {
+			resp.Status, code = "unavailable", http.StatusServiceUnavailable
+		}
+		for _, r := range resp.Runners {
+			if !ok(r) {
+				resp.Status, code = "unavailable", http.StatusServiceUnavailable
+				break
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Debugf("write health response failed: %v", err)
+		}
+	}
+}
+
+func healthLive(r *healthReport) bool { return r.Started }
+
+func healthReady(r *healthReport) bool { return r.ready() }
+
+// health checks the connections of the runner, the holder of the running lock and the lag of
+// startHeightKey behind the source chain.
+func (a *runner) health(ctx context.Context) *healthReport {
+	report := &healthReport{Runner: a.String(), Started: a.once.Load(), Role: rolePassive}
+	if !report.Started {
+		return report
+	}
+	// the connections closed meanwhile are reported as failed
+	src, target, store := a._connections()
+
+	if a.needs.Bool(NeedSource) {
+		if src == nil {
+			report.Source = newConnStatus(errors.New("not connected"))
+		} else {
+			cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
+			stats, err := src.ChainStats(cctx)
+			cancel()
+			report.Source = newConnStatus(err)
+			if err == nil && stats != nil {
+				current := uint64(stats.CurrentHeight)
+				report.SourceHeight = &current
+			}
+		}
+	}
+	if a.needs.Bool(NeedTarget) {
+		if target == nil {
+			report.Target = newConnStatus(errors.New("not connected"))
+		} else {
+			report.Target = newConnStatus(target.alive(ctx))
+		}
+	}
+	if a.needs.Bool(NeedRedis) && store != nil {
+		cctx, cancel := context.WithTimeout(ctx, redisTimeout)
+		defer cancel()
+		report.Lock = &lockStatus{Key: a.keys.runnerLockKey, Self: a.keys.runnerLockValue}
+		holder, err := store.LockHolder(cctx, a.keys.runnerLockKey)
+		if err != nil {
+			report.Lock.Error = err.Error()
+		}
+		report.Lock.Holder = holder
+		if report.Lock.Holder == a.keys.runnerLockValue {
+			report.Role = roleActive
+		}
+		if _, isLooper := a.bHandler.(looperHandler); isLooper {
+			start := a.conf.SrcStartHeight
+			if h, exist, err := store.Cursor(cctx, a.keys.startHeightKey); err == nil && exist {
+				start = h
+			}
+			report.StartHeight = &start
+			if report.SourceHeight != nil {
+				var lag uint64
+				if *report.SourceHeight > start {
+					lag = *report.SourceHeight - start
+				}
+				report.Lag = &lag
+			}
+		}
+	}
+	return report
+}
@@ -0,0 +1,104 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type _fixedReport healthReport
+
+func (f *_fixedReport) health(_ context.Context) *healthReport {
+	r := healthReport(*f)
+	return &r
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	registry := &healthRegistry{reporters: make(map[string]healthReporter)}
+	get := func(ok func(r *healthReport) bool) (int, *healthResponse) {
+		rec := httptest.NewRecorder()
+		registry.handler(ok)(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		resp := new(healthResponse)
+		if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, _ := get(healthReady); code != http.StatusServiceUnavailable {
+		t.Fatalf("no runner should not be ready, got %d", code)
+	}
+
+	lag := uint64(12)
+	passive := &_fixedReport{Runner: "B", Started: true, Role: rolePassive,
+		Source: newConnStatus(nil), Target: newConnStatus(nil),
+		Lock: &lockStatus{Key: "lock", Self: "10.0.0.2@2", Holder: "10.0.0.1@1"}, Lag: &lag}
+	registry.register("B", passive)
+	code, resp := get(healthReady)
+	if code != http.StatusOK || len(resp.Runners) != 1 || resp.Runners[0].Role != rolePassive || *resp.Runners[0].Lag != 12 {
+		t.Fatalf("passive runner should be ready: %d %+v", code, resp)
+	}
+
+	broken := &_fixedReport{Runner: "A", Started: true, Role: roleActive,
+		Source: newConnStatus(errors.New("connection refused")), Target: newConnStatus(nil)}
+	registry.register("A", broken)
+	if code, resp = get(healthReady); code != http.StatusServiceUnavailable || resp.Runners[0].Runner != "A" {
+		t.Fatalf("broken source should not be ready: %d %+v", code, resp)
+	}
+	if code, _ = get(healthLive); code != http.StatusOK {
+		t.Fatalf("started runners should be alive, got %d", code)
+	}
+
+	registry.unregister("A")
+	if code, _ = get(healthReady); code != http.StatusOK {
+		t.Fatalf("should be ready after unregister, got %d", code)
+	}
+	t.Logf("%+v", resp)
+}
+
+func TestRunnerHealthNotStarted(t *testing.T) {
+	a := &runner{conf: &Config{TargetName: "HEALTH"}, needs: new(BitFlags).Set(NeedSource, NeedTarget, NeedRedis)}
+	r := a.health(context.Background())
+	if r.Started || r.ready() || r.Role != rolePassive {
+		t.Fatalf("not started runner: %+v", r)
+	}
+}
+
+func TestRunnerHealthWhileClosing(t *testing.T) {
+	a := &runner{conf: &Config{TargetName: "HEALTH"}, needs: new(BitFlags).Set(NeedSource, NeedTarget)}
+	a.once.Store(true)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if r := a.health(context.Background()); r.Started && r.ready() {
+				t.Errorf("runner without connections should not be ready: %+v", r)
+				return
+			}
+		}
+	}()
+	if err := a.close(nil); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if r := a.health(context.Background()); r.Started {
+		t.Fatalf("closed runner: %+v", r)
+	}
+}
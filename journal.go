@@ -0,0 +1,309 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	TxJournalKeyInContext  = "tx_journal"
+	JournalRefKeyInContext = "journal_ref"
+
+	journalKeySuffix = "_journal"
+)
+
+// journalEntry is a tx sent to the target chain whose receipt has not been received
+type journalEntry struct {
+	Hash   string `json:"hash"`
+	Nonce  uint64 `json:"nonce"`
+	Raw    string `json:"raw"`    // binary encoding of the signed tx
+	Ref    string `json:"ref"`    // what the tx is for, such as the orderId or epoch
+	Runner string `json:"runner"` // name of the runner who sent it
+	SentAt int64  `json:"sentAt"` // unix seconds
+}
+
+func newJournalEntry(tx *types.Transaction, ref, runner string) (*journalEntry, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &journalEntry{
+		Hash:   tx.Hash().Hex(),
+		Nonce:  tx.Nonce(),
+		Raw:    hexutil.Encode(raw),
+		Ref:    ref,
+		Runner: runner,
+		SentAt: time.Now().Unix(),
+	}, nil
+}
+
+func (e *journalEntry) String() string {
+	if e == nil {
+		return "Journal<nil>"
+	}
+	return fmt.Sprintf("Journal{%s Nonce:%d Ref:%s Runner:%s SentAt:%s}", e.Hash, e.Nonce, e.Ref,
+		e.Runner, time.Unix(e.SentAt, 0).Format(timeFormat))
+}
+
+func (e *journalEntry) tx() (*types.Transaction, error) {
+	raw, err := hexutil.Decode(e.Raw)
+	if err != nil {
+		return nil, err
+	}
+	tx := new(types.Transaction)
+	if err = tx.UnmarshalBinary(raw); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// txJournal records the in-flight txs of a sender in a map of the state store, so that a new process knows
+// what have been sent by the dead one.
+type txJournal struct {
+	store  StateStore
+	key    string
+	runner string
+}
+
+func newTxJournal(store StateStore, senderLockKey, runner string) *txJournal {
+	return &txJournal{store: store, key: senderLockKey + journalKeySuffix, runner: runner}
+}
+
+func (j *txJournal) String() string {
+	if j == nil {
+		return "TxJournal<nil>"
+	}
+	return fmt.Sprintf("TxJournal{%s}", j.key)
+}
+
+func (j *txJournal) add(ctx context.Context, tx *types.Transaction, ref string) error {
+	entry, err := newJournalEntry(tx, ref, j.runner)
+	if err != nil {
+		return fmt.Errorf("journal entry failed: %w", err)
+	}
+	bs, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("journal entry marshal failed: %w", err)
+	}
+	cctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if err = j.store.HSet(cctx, j.key, entry.Hash, string(bs)); err != nil {
+		return fmt.Errorf("journal %s failed: %w", entry, err)
+	}
+	log.Debugf("%s journaled", entry)
+	return nil
+}
+
+// ref returns the reference of the journaled tx, or empty string if not found
+func (j *txJournal) ref(ctx context.Context, tx *types.Transaction) string {
+	cctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	value, exist, err := j.store.HGet(cctx, j.key, tx.Hash().Hex())
+	if err != nil || !exist {
+		return ""
+	}
+	entry := new(journalEntry)
+	if err = json.Unmarshal([]byte(value), entry); err != nil {
+		return ""
+	}
+	return entry.Ref
+}
+
+func (j *txJournal) remove(ctx context.Context, hashes ...string) {
+	if len(hashes) == 0 {
+		return
+	}
+	cctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	if err := j.store.HDel(cctx, j.key, hashes...); err != nil {
+		log.Warnf("remove %s from %s failed: %v", hashes, j, err)
+	}
+}
+
+// list returns all the journaled txs ordered by nonce and sending time
+func (j *txJournal) list(ctx context.Context) ([]*journalEntry, error) {
+	cctx, cancel := context.WithTimeout(ctx, redisTimeout)
+	defer cancel()
+	all, err := j.store.HGetAll(cctx, j.key)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*journalEntry, 0, len(all))
+	for field, value := range all {
+		entry := new(journalEntry)
+		if err := json.Unmarshal([]byte(value), entry); err != nil {
+			log.Warnf("invalid journal entry %s: %v", field, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	sortJournalEntries(entries)
+	return entries, nil
+}
+
+func sortJournalEntries(entries []*journalEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Nonce != entries[j].Nonce {
+			return entries[i].Nonce < entries[j].Nonce
+		}
+		return entries[i].SentAt < entries[j].SentAt
+	})
+}
+
+// groupByNonce groups the sorted entries, every group is a tx and its replacements
+func groupByNonce(entries []*journalEntry) [][]*journalEntry {
+	var groups [][]*journalEntry
+	for i, e := range entries {
+		if i == 0 || e.Nonce != entries[i-1].Nonce {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], e)
+	}
+	return groups
+}
+
+func entryHashes(entries []*journalEntry) []string {
+	ret := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ret = append(ret, e.Hash)
+	}
+	return ret
+}
+
+func txHashes(txs []*types.Transaction) []string {
+	ret := make([]string, 0, len(txs))
+	for _, tx := range txs {
+		ret = append(ret, tx.Hash().Hex())
+	}
+	return ret
+}
+
+func putTxJournal(ctx context.Context, journal *txJournal, ref string) context.Context {
+	if journal == nil {
+		return ctx
+	}
+	ctx = context.WithValue(ctx, TxJournalKeyInContext, journal)
+	return context.WithValue(ctx, JournalRefKeyInContext, ref)
+}
+
+func getTxJournal(ctx context.Context) (*txJournal, string) {
+	if ctx == nil {
+		return nil, ""
+	}
+	j, ok := ctx.Value(TxJournalKeyInContext).(*txJournal)
+	if !ok {
+		return nil, ""
+	}
+	ref, _ := ctx.Value(JournalRefKeyInContext).(string)
+	return j, ref
+}
+
+// reconcileJournal deals with the txs left by the previous process: the mined or overwritten ones
+// are dropped, and the others are re-broadcast and waited for.
+func (a *runner) reconcileJournal(cctx *cli.Context) (errr error) {
+	if a.journal == nil || a.target == nil {
+		return nil
+	}
+	entries, err := a.journal.list(cctx.Context)
+	if err != nil {
+		return fmt.Errorf("list %s failed: %w", a.journal, err)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	lockingValue, err := a.sendingLock.Fetch(cctx.Context)
+	if err != nil {
+		log.Warnf("[%s] is sending, %d entries in %s will be reconciled later", lockingValue, len(entries), a.journal)
+		return nil
+	}
+	defer func() {
+		_ = a.sendingLock.Release()
+	}()
+	keeper := keepLease(cctx.Context, a.sendingLock)
+	defer keeper.stop(&errr)
+	cctx = withContext(cctx, keeper.Context())
+
+	confirmed, err := a.target.confirmedNonce(cctx.Context, a.targetPriv.Address())
+	if err != nil {
+		return fmt.Errorf("get confirmed nonce failed: %w", err)
+	}
+	var pendings []*types.Transaction
+	for _, group := range groupByNonce(entries) {
+		if mined := a._journalMined(cctx.Context, group); mined != nil {
+			log.Infof("%s mined, dropped with %d replacements", mined, len(group)-1)
+			a.journal.remove(cctx.Context, entryHashes(group)...)
+			continue
+		}
+		if group[0].Nonce < confirmed {
+			log.Warnf("Nonce:%d used by another tx, dropped %s", group[0].Nonce, group)
+			a.journal.remove(cctx.Context, entryHashes(group)...)
+			continue
+		}
+		// the last one has the highest fees
+		latest := group[len(group)-1]
+		tx, err := latest.tx()
+		if err != nil {
+			log.Warnf("%s decode failed, dropped: %v", latest, err)
+			a.journal.remove(cctx.Context, latest.Hash)
+			continue
+		}
+		if err = a.target.sendTransaction(putDistributedLock(cctx.Context, a.sendingLock), tx); err != nil {
+			log.Warnf("re-broadcast %s failed: %v", latest, err)
+		} else {
+			log.Infof("%s re-broadcast", latest)
+		}
+		pendings = append(pendings, tx)
+	}
+	if len(pendings) == 0 {
+		return nil
+	}
+	ctx := putTxJournal(putDistributedLock(cctx.Context, a.sendingLock), a.journal, "")
+	rcpts, err := a.target.checkReceipts(ctx, a.targetPriv.Priv(), pendings...)
+	if err != nil {
+		return fmt.Errorf("wait for journaled txs failed: %w", err)
+	}
+	for i, rcpt := range rcpts {
+		if rcpt == nil {
+			log.Warnf("receipt of journaled tx %x (Nonce:%d) not found, it will be checked in the next start",
+				pendings[i].Hash().Bytes(), pendings[i].Nonce())
+		} else {
+			log.Infof("journaled tx %x (Nonce:%d) mined, success:%t", rcpt.TxHash[:], pendings[i].Nonce(), rcpt.Success())
+		}
+	}
+	return nil
+}
+
+func (a *runner) _journalMined(ctx context.Context, group []*journalEntry) *journalEntry {
+	for _, e := range group {
+		tx, err := e.tx()
+		if err != nil {
+			continue
+		}
+		if rec, err := a.target.getReceipt(ctx, tx.Hash()); err == nil && rec != nil {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestJournalEntry(t *testing.T) {
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     7,
+		GasTipCap: big.NewInt(2),
+		GasFeeCap: big.NewInt(100),
+		Gas:       21000,
+		Data:      []byte{1, 2, 3},
+	})
+	entry, err := newJournalEntry(tx, "Epoch:10", "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := entry.tx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Hash() != tx.Hash() || entry.Hash != tx.Hash().Hex() || entry.Nonce != 7 {
+		t.Fatalf("%s decoded as %x", entry, decoded.Hash())
+	}
+	t.Log(entry)
+}
+
+func TestGroupByNonce(t *testing.T) {
+	entries := []*journalEntry{
+		{Hash: "d", Nonce: 3, SentAt: 5},
+		{Hash: "b", Nonce: 1, SentAt: 9},
+		{Hash: "a", Nonce: 1, SentAt: 1},
+		{Hash: "c", Nonce: 2, SentAt: 3},
+	}
+	sortJournalEntries(entries)
+	groups := groupByNonce(entries)
+	want := [][]string{{"a", "b"}, {"c"}, {"d"}}
+	if len(groups) != len(want) {
+		t.Fatalf("want %d groups, got %d", len(want), len(groups))
+	}
+	for i, group := range groups {
+		hashes := entryHashes(group)
+		if len(hashes) != len(want[i]) {
+			t.Fatalf("group %d want:%v got:%v", i, want[i], hashes)
+		}
+		for j := range hashes {
+			if hashes[j] != want[i][j] {
+				t.Fatalf("group %d want:%v got:%v", i, want[i], hashes)
+			}
+		}
+	}
+}
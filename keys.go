@@ -0,0 +1,377 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	senderLockPrefix   = "targetSender"
+	namespaceSeparator = ":"
+	dryRunNamespace    = "dryrun"
+
+	roleStartHeight    = "startHeight"
+	roleLastUpdateTime = "lastUpdateTime"
+	roleJournal        = "journal"
+)
+
+// keyBuilder builds the keys in the state store, all prefixed by the namespace if it's not empty
+type keyBuilder string
+
+func (ns keyBuilder) key(k string) string {
+	if len(ns) == 0 {
+		return k
+	}
+	return string(ns) + namespaceSeparator + k
+}
+
+func (ns keyBuilder) startHeight(runnerName string, chainId common.ChainID) string {
+	return ns.key(fmt.Sprintf("%s_start_%d", strings.ToLower(runnerName), chainId))
+}
+
+func (ns keyBuilder) runnerLock(runnerName string, chainId common.ChainID) string {
+	return ns.key(fmt.Sprintf("%s_lock_%d", strings.ToLower(runnerName), chainId))
+}
+
+func (ns keyBuilder) lastUpdateTime(runnerName string, chainId common.ChainID) string {
+	return ns.key(fmt.Sprintf("%s_lastTimeStamp_%d", strings.ToLower(runnerName), chainId))
+}
+
+func (ns keyBuilder) senderLock(targetChainId *big.Int, sender common.Address) string {
+	return ns.key(fmt.Sprintf("%s_%d_0x%x", senderLockPrefix, targetChainId, sender.Bytes()))
+}
+
+// runnerKeys are the keys of a runner, identified by its type, name and chains
+type runnerKeys struct {
+	ns            keyBuilder
+	runnerType    string // one of maintain, sync, update, xmaintain, xsync
+	targetName    string
+	srcChainId    common.ChainID
+	targetChainId *big.Int
+	sender        *common.Address // nil if the journal is not concerned
+}
+
+func (k *runnerKeys) runnerName() string {
+	return strings.ToUpper(k.runnerType) + "_" + k.targetName
+}
+
+func (k *runnerKeys) String() string {
+	return fmt.Sprintf("Keys{NS:%q %s SrcChain:%d}", string(k.ns), k.runnerName(), k.srcChainId)
+}
+
+func (k *runnerKeys) lockKey() string {
+	return k.ns.runnerLock(k.runnerName(), k.srcChainId)
+}
+
+// roles returns the keys of the states need to be kept by role, locks and fencing counters are
+// not included, which are useless to a new owner.
+func (k *runnerKeys) roles() map[string]string {
+	roles := make(map[string]string)
+	if k.runnerType == "update" {
+		roles[roleLastUpdateTime] = k.ns.lastUpdateTime(k.runnerName(), k.srcChainId)
+	} else {
+		roles[roleStartHeight] = k.ns.startHeight(k.runnerName(), k.srcChainId)
+	}
+	if k.sender != nil && k.targetChainId != nil {
+		roles[roleJournal] = k.ns.senderLock(k.targetChainId, *k.sender) + journalKeySuffix
+	}
+	return roles
+}
+
+// stateEntry is an exported state, Fields for a map and Value for others
+type stateEntry struct {
+	Role   string            `json:"role"`
+	Key    string            `json:"key"`
+	Value  string            `json:"value,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+type stateExport struct {
+	Namespace string        `json:"namespace"`
+	Runner    string        `json:"runner"`
+	Entries   []*stateEntry `json:"entries"`
+}
+
+func exportStates(ctx context.Context, store StateStore, keys *runnerKeys) (*stateExport, error) {
+	exp := &stateExport{Namespace: string(keys.ns), Runner: keys.runnerName()}
+	for _, role := range []string{roleStartHeight, roleLastUpdateTime, roleJournal} {
+		key, ok := keys.roles()[role]
+		if !ok {
+			continue
+		}
+		entry := &stateEntry{Role: role, Key: key}
+		if role == roleJournal {
+			fields, err := store.HGetAll(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("export %s failed: %w", key, err)
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			entry.Fields = fields
+		} else {
+			value, exist, err := store.Get(ctx, key)
+			if err != nil {
+				return nil, fmt.Errorf("export %s failed: %w", key, err)
+			}
+			if !exist {
+				continue
+			}
+			entry.Value = value
+		}
+		exp.Entries = append(exp.Entries, entry)
+	}
+	return exp, nil
+}
+
+// importStates writes the exported states to the keys of the same roles. Existing states would not
+// be overwritten unless force.
+func importStates(ctx context.Context, store StateStore, keys *runnerKeys, exp *stateExport, force bool) error {
+	roles := keys.roles()
+	for _, entry := range exp.Entries {
+		key, ok := roles[entry.Role]
+		if !ok {
+			log.Warnf("%s of %s not available in %s, skipped", entry.Role, exp.Runner, keys)
+			continue
+		}
+		if !force {
+			var exist bool
+			if entry.Role == roleJournal {
+				fields, err := store.HGetAll(ctx, key)
+				if err != nil {
+					return err
+				}
+				exist = len(fields) > 0
+			} else {
+				var err error
+				if _, exist, err = store.Get(ctx, key); err != nil {
+					return err
+				}
+			}
+			if exist {
+				return fmt.Errorf("%s already exists, use --%s to overwrite", key, _keysForceFlag.Name)
+			}
+		}
+		if entry.Role == roleJournal {
+			for field, value := range entry.Fields {
+				if err := store.HSet(ctx, key, field, value); err != nil {
+					return fmt.Errorf("import %s failed: %w", key, err)
+				}
+			}
+		} else if err := store.Set(ctx, key, entry.Value); err != nil {
+			return fmt.Errorf("import %s failed: %w", key, err)
+		}
+		log.Infof("%s imported from %s to %s", entry.Role, entry.Key, key)
+	}
+	return nil
+}
+
+// _ensureIdle returns an error if the runner is running, states should not be moved under its feet
+func _ensureIdle(ctx context.Context, store StateStore, keys *runnerKeys) error {
+	holder, err := store.LockHolder(ctx, keys.lockKey())
+	if err != nil {
+		return err
+	}
+	if len(holder) > 0 {
+		return fmt.Errorf("%s is running by [%s]", keys.runnerName(), holder)
+	}
+	return nil
+}
+
+func _keysOfContext(ctx *cli.Context) (*runnerKeys, error) {
+	runnerType := strings.ToLower(ctx.String(_keysRunnerFlag.Name))
+	switch runnerType {
+	case "maintain", "sync", "update", "xmaintain", "xsync":
+	default:
+		return nil, fmt.Errorf("invalid %s: %q", _keysRunnerFlag.Name, runnerType)
+	}
+	keys := &runnerKeys{
+		ns:         keyBuilder(ctx.String(_namespaceFlag.Name)),
+		runnerType: runnerType,
+		targetName: strings.ToUpper(ctx.String(_targetNameFlag.Name)),
+		srcChainId: common.ChainID(ctx.Uint64(_srcChainFlag.Name)),
+	}
+	if keys.targetName == "" {
+		return nil, errors.New("target.name required")
+	}
+	if cid := ctx.Uint64(_targetChainIDFlag.Name); cid > 0 {
+		keys.targetChainId = new(big.Int).SetUint64(cid)
+	}
+	if s := strings.TrimPrefix(ctx.String(_keysSenderFlag.Name), "0x"); len(s) > 0 {
+		bs, err := hex.DecodeString(s)
+		if err != nil || len(bs) != common.AddressLength {
+			return nil, fmt.Errorf("invalid %s", _keysSenderFlag.Name)
+		}
+		sender := common.BytesToAddress(bs)
+		keys.sender = &sender
+	}
+	if keys.sender != nil && keys.targetChainId == nil {
+		return nil, fmt.Errorf("target.chainid required for the journal of %s", _keysSenderFlag.Name)
+	}
+	return keys, nil
+}
+
+func _storeOfContext(ctx *cli.Context) (StateStore, error) {
+	store, err := newStateStore(&Config{
+		RedisAddr: ctx.String(_redisFlag.Name),
+		StoreFile: ctx.String(_storeFileFlag.Name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = store.Ping(ctx.Context); err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("ping %s failed: %w", store, err)
+	}
+	return store, nil
+}
+
+// _withKeys opens the store and calls fn with the keys of the runner, errors are returned as
+// cli.ExitCoder
+func _withKeys(ctx *cli.Context, fn func(store StateStore, keys *runnerKeys) error) error {
+	keys, err := _keysOfContext(ctx)
+	if err != nil {
+		return cli.Exit(err, ExitByConfig)
+	}
+	store, err := _storeOfContext(ctx)
+	if err != nil {
+		return cli.Exit(err, ExitRedisErr)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	if err = fn(store, keys); err != nil {
+		return cli.Exit(err, ExitRedisErr)
+	}
+	return nil
+}
+
+func keysList(ctx *cli.Context) error {
+	return _withKeys(ctx, func(store StateStore, keys *runnerKeys) error {
+		holder, err := store.LockHolder(ctx.Context, keys.lockKey())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%s\t%s\n", "lock", keys.lockKey(), holder)
+		exp, err := exportStates(ctx.Context, store, keys)
+		if err != nil {
+			return err
+		}
+		for _, entry := range exp.Entries {
+			if entry.Fields != nil {
+				fmt.Printf("%s\t%s\t%d entries\n", entry.Role, entry.Key, len(entry.Fields))
+			} else {
+				fmt.Printf("%s\t%s\t%s\n", entry.Role, entry.Key, entry.Value)
+			}
+		}
+		return nil
+	})
+}
+
+func keysExport(ctx *cli.Context) error {
+	return _withKeys(ctx, func(store StateStore, keys *runnerKeys) error {
+		exp, err := exportStates(ctx.Context, store, keys)
+		if err != nil {
+			return err
+		}
+		var w io.Writer = os.Stdout
+		if path := ctx.String(_keysFileFlag.Name); len(path) > 0 {
+			f, err := os.Create(path)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				_ = f.Close()
+			}()
+			w = f
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(exp)
+	})
+}
+
+func keysImport(ctx *cli.Context) error {
+	return _withKeys(ctx, func(store StateStore, keys *runnerKeys) error {
+		path := ctx.String(_keysFileFlag.Name)
+		if len(path) == 0 {
+			return fmt.Errorf("%s required", _keysFileFlag.Name)
+		}
+		bs, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		exp := new(stateExport)
+		if err = json.Unmarshal(bs, exp); err != nil {
+			return fmt.Errorf("invalid export file: %w", err)
+		}
+		if err = _ensureIdle(ctx.Context, store, keys); err != nil {
+			return err
+		}
+		return importStates(ctx.Context, store, keys, exp, ctx.Bool(_keysForceFlag.Name))
+	})
+}
+
+func keysMigrate(ctx *cli.Context) error {
+	return _withKeys(ctx, func(store StateStore, from *runnerKeys) error {
+		to := *from
+		if ctx.IsSet(_keysToNamespaceFlag.Name) {
+			to.ns = keyBuilder(ctx.String(_keysToNamespaceFlag.Name))
+		}
+		if name := ctx.String(_keysToNameFlag.Name); len(name) > 0 {
+			to.targetName = strings.ToUpper(name)
+		}
+		if to.ns == from.ns && to.targetName == from.targetName {
+			return errors.New("nothing to migrate")
+		}
+		for _, keys := range []*runnerKeys{from, &to} {
+			if err := _ensureIdle(ctx.Context, store, keys); err != nil {
+				return err
+			}
+		}
+		exp, err := exportStates(ctx.Context, store, from)
+		if err != nil {
+			return err
+		}
+		if err = importStates(ctx.Context, store, &to, exp, ctx.Bool(_keysForceFlag.Name)); err != nil {
+			return err
+		}
+		if ctx.Bool(_keysDeleteFlag.Name) {
+			var olds []string
+			for _, entry := range exp.Entries {
+				olds = append(olds, entry.Key)
+			}
+			if err = store.Del(ctx.Context, olds...); err != nil {
+				return fmt.Errorf("delete %s failed: %w", olds, err)
+			}
+			log.Infof("%s deleted", olds)
+		}
+		log.Infof("%d states migrated from %s to %s", len(exp.Entries), from, &to)
+		return nil
+	})
+}
@@ -0,0 +1,140 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"math/big"
+	"testing"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/urfave/cli/v2"
+)
+
+const testSenderKey = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+// runnerTestContext returns a context with the global flags and the flags of a runner, values
+// are set as they are given in the command line
+func runnerTestContext(t *testing.T, flags []cli.Flag, values map[string]string) *cli.Context {
+	set := flag.NewFlagSet("runner", flag.ContinueOnError)
+	for _, f := range joinFlags(_allFlags, flags) {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, value := range values {
+		if err := set.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cctx := cli.NewContext(cli.NewApp(), set, nil)
+	cctx.Context = context.Background()
+	return cctx
+}
+
+func TestKeyBuilder(t *testing.T) {
+	sender := common.BytesToAddress([]byte{0x01, 0x02})
+	var ns keyBuilder
+	// same as the keys before namespace introduced
+	if k := ns.startHeight("SYNC_BSC", 50001); k != "sync_bsc_start_50001" {
+		t.Fatalf("invalid start key: %s", k)
+	}
+	if k := ns.senderLock(big.NewInt(97), sender); k != "targetSender_97_0x0000000000000000000000000000000000000102" {
+		t.Fatalf("invalid sender key: %s", k)
+	}
+	ns = "testnet"
+	if k := ns.runnerLock("MAINTAIN_BSC", 50001); k != "testnet:maintain_bsc_lock_50001" {
+		t.Fatalf("invalid lock key: %s", k)
+	}
+	if k := ns.lastUpdateTime("UPDATE_BSC", 50001); k != "testnet:update_bsc_lastTimeStamp_50001" {
+		t.Fatalf("invalid last update key: %s", k)
+	}
+}
+
+func TestDryRunKeys(t *testing.T) {
+	h, err := newRunnerOfType("update")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cctx := runnerTestContext(t, _updateFlags, map[string]string{
+		_targetNameFlag.Name:      "BSC",
+		_targetPrivFlag.Name:      testSenderKey,
+		_namespaceFlag.Name:       "testnet",
+		_dryRunFlag.Name:          "true",
+		_srcChainFlag.Name:        "50001",
+		_updaterTargetLCFlag.Name: "0000000000000000000000000000000000000001",
+	})
+	if err := h.prepareConfig(cctx); err != nil {
+		t.Fatal(err)
+	}
+	u := h.(*updater)
+	if k := u.lastUpdateTimeKey; k != "testnet:dryrun:update_bsc_lastTimeStamp_50001" {
+		t.Fatalf("invalid dry-run last update key: %s", k)
+	}
+	if k := u.keys.runnerLockKey; k != "testnet:dryrun:update_bsc_lock_50001" {
+		t.Fatalf("invalid dry-run lock key: %s", k)
+	}
+}
+
+func TestMigrateStates(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	ctx := context.Background()
+	sender := common.BytesToAddress([]byte{0xaa})
+	from := &runnerKeys{runnerType: "sync", targetName: "BSC", srcChainId: 50001,
+		targetChainId: big.NewInt(97), sender: &sender}
+	roles := from.roles()
+	_ = store.SetCursor(ctx, roles[roleStartHeight], 12345)
+	_ = store.HSet(ctx, roles[roleJournal], "0x01", `{"hash":"0x01"}`)
+
+	exp, err := exportStates(ctx, store, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.Entries) != 2 {
+		t.Fatalf("2 entries expected: %+v", exp.Entries)
+	}
+
+	to := *from
+	to.ns, to.targetName = "prod", "BSC2"
+	if err := importStates(ctx, store, &to, exp, false); err != nil {
+		t.Fatal(err)
+	}
+	if h, exist, _ := store.Cursor(ctx, "prod:sync_bsc2_start_50001"); !exist || h != 12345 {
+		t.Fatalf("cursor not migrated: %d %t", h, exist)
+	}
+	if all, _ := store.HGetAll(ctx, "prod:targetSender_97_0x00000000000000000000000000000000000000aa_journal"); len(all) != 1 {
+		t.Fatalf("journal not migrated: %v", all)
+	}
+	if err := importStates(ctx, store, &to, exp, false); err == nil {
+		t.Fatal("existing states should not be overwritten")
+	}
+
+	// a running runner should not be migrated
+	if _, err := store.ObtainLock(ctx, to.lockKey(), "10.0.0.1@1", minLeaseInterval*100); err != nil {
+		t.Fatal(err)
+	}
+	if err := _ensureIdle(ctx, store, &to); err == nil {
+		t.Fatal("running runner should be detected")
+	} else {
+		t.Log(err)
+	}
+}
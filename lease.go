@@ -0,0 +1,137 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sc "sync"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/urfave/cli/v2"
+)
+
+const minLeaseInterval = 100 * time.Millisecond
+
+// leaseKeeper refreshes the held locks in background at 1/3 of the shortest TTL. Its context is
+// cancelled as soon as any of the leases is lost, that is the lock has been taken by others, or
+// it has not been refreshed successfully for a whole TTL.
+type leaseKeeper struct {
+	locks  []*storeLock
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+	lock   sc.Mutex
+	err    error
+}
+
+func keepLease(parent context.Context, locks ...*storeLock) *leaseKeeper {
+	ctx, cancel := context.WithCancel(parent)
+	k := &leaseKeeper{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+	var interval time.Duration
+	for _, l := range locks {
+		if l == nil {
+			continue
+		}
+		k.locks = append(k.locks, l)
+		if interval == 0 || l.ttl/3 < interval {
+			interval = l.ttl / 3
+		}
+	}
+	if interval < minLeaseInterval {
+		interval = minLeaseInterval
+	}
+	go k._loop(interval)
+	return k
+}
+
+func (k *leaseKeeper) _loop(interval time.Duration) {
+	defer close(k.done)
+	refreshed := make([]time.Time, len(k.locks))
+	for i := range refreshed {
+		refreshed[i] = time.Now()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.ctx.Done():
+			return
+		case <-ticker.C:
+			for i, l := range k.locks {
+				if !l.Holding() {
+					k._lost(fmt.Errorf("lease of %s lost: %w", l, ErrLockNotHeld))
+					return
+				}
+				err := l.Refresh(k.ctx)
+				switch {
+				case err == nil:
+					refreshed[i] = time.Now()
+				case k.ctx.Err() != nil:
+					return
+				case errors.Is(err, ErrLockNotHeld) || time.Since(refreshed[i]) >= l.ttl:
+					k._lost(fmt.Errorf("lease of %s lost: %w", l, err))
+					return
+				}
+			}
+		}
+	}
+}
+
+func (k *leaseKeeper) _lost(err error) {
+	k.lock.Lock()
+	k.err = err
+	k.lock.Unlock()
+	log.Errorf("%v, cancelling", err)
+	k.cancel()
+}
+
+// Context returns the context which is cancelled when any lease is lost
+func (k *leaseKeeper) Context() context.Context {
+	return k.ctx
+}
+
+// Err returns why the context is cancelled by the keeper, nil if not lost
+func (k *leaseKeeper) Err() error {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	return k.err
+}
+
+// stop stops refreshing. If any lease has been lost, the error is set to *errp.
+func (k *leaseKeeper) stop(errp *error) {
+	k.cancel()
+	<-k.done
+	if lost := k.Err(); lost != nil && errp != nil {
+		*errp = lost
+	}
+}
+
+// withContext returns a copy of cctx with ctx as its context
+func withContext(cctx *cli.Context, ctx context.Context) *cli.Context {
+	c := *cctx
+	c.Context = ctx
+	return &c
+}
+
+// _underLease runs fn while keeping the leases of locks, fn stops on the cancellation of the
+// context once any lease is lost, and the lost error returns.
+func (a *runner) _underLease(cctx *cli.Context, fn func(cctx *cli.Context) error, locks ...*storeLock) (err error) {
+	keeper := keepLease(cctx.Context, locks...)
+	defer keeper.stop(&err)
+	return fn(withContext(cctx, keeper.Context()))
+}
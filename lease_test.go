@@ -0,0 +1,69 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLeaseKeeper(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	var offset atomic.Int64
+	store.now = func() time.Time { return time.Now().Add(time.Duration(offset.Load())) }
+	ctx := context.Background()
+
+	l1 := newStoreLock(store, "running", "10.0.0.1@1", 600*time.Millisecond)
+	if _, err := l1.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	keeper := keepLease(ctx, l1)
+	time.Sleep(1500 * time.Millisecond)
+	select {
+	case <-keeper.Context().Done():
+		t.Fatalf("lease should be kept: %v", keeper.Err())
+	default:
+	}
+	if holder, _ := store.LockHolder(ctx, "running"); holder != "10.0.0.1@1" {
+		t.Fatalf("lease should be refreshed, holder: %q", holder)
+	}
+
+	// the lease expires while the process stalled, and taken by another one
+	offset.Store(int64(time.Second))
+	l2 := newStoreLock(store, "running", "10.0.0.2@2", 600*time.Millisecond)
+	if _, err := l2.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-keeper.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("context should be cancelled once the lease lost")
+	}
+	var result error
+	keeper.stop(&result)
+	if !errors.Is(result, ErrLockNotHeld) {
+		t.Fatalf("lost error expected, got %v", result)
+	}
+	t.Log(result)
+}
@@ -17,6 +17,7 @@ package main
 import (
 	"fmt"
 	"math/big"
+	sc "sync"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/abi"
@@ -1225,8 +1226,12 @@ const (
 	checkEpochCommName = "checkEpochCommittee"
 )
 
+var tkmLNAbiOnce sc.Once
+
 func initTKMLNAbi() {
-	LightNodeABI = *abi.MustInitAbi("tkm light node", tkmlcstring)
+	tkmLNAbiOnce.Do(func() {
+		LightNodeABI = *abi.MustInitAbi("tkm light node", tkmlcstring)
+	})
 }
 
 type (
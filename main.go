@@ -76,6 +76,75 @@ func main() {
 				Action:   pemfile,
 				Flags:    _pemFlags,
 			},
+			{
+				Name:     "keys",
+				Usage:    "list, export, import or migrate the states of a runner in the state store",
+				Category: "MISC",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "list",
+						Usage:  "list the states of the runner",
+						Action: keysList,
+						Flags:  _keysFlags,
+					},
+					{
+						Name:   "export",
+						Usage:  "export the states of the runner in JSON",
+						Action: keysExport,
+						Flags:  append([]cli.Flag{_keysFileFlag}, _keysFlags...),
+					},
+					{
+						Name:   "import",
+						Usage:  "import the exported states to the runner",
+						Action: keysImport,
+						Flags:  append([]cli.Flag{_keysFileFlag, _keysForceFlag}, _keysFlags...),
+					},
+					{
+						Name:   "migrate",
+						Usage:  "migrate the states of the runner to another namespace or target name",
+						Action: keysMigrate,
+						Flags:  append([]cli.Flag{_keysToNamespaceFlag, _keysToNameFlag, _keysForceFlag, _keysDeleteFlag}, _keysFlags...),
+					},
+				},
+			},
+			{
+				Name:     "serve",
+				Usage:    "run the routes listed in a YAML file in one process, sharing the source connections and the state store",
+				Category: "MISC",
+				Action:   serve,
+				Flags:    _serveFlags,
+				Before:   altsrc.InitInputSourceWithContext(_serveFlags, altsrc.NewYamlSourceFromFlagFunc(_confFileFlag.Name)),
+			},
+			{
+				Name:     "status",
+				Usage:    "print the cursors, locks, light nodes and senders of all the configured runners",
+				Category: "MISC",
+				Action:   status,
+				Flags:    _statusFlags,
+				Before:   altsrc.InitInputSourceWithContext(_statusFlags, altsrc.NewYamlSourceFromFlagFunc(_confFileFlag.Name)),
+			},
+			{
+				Name:     "cursor",
+				Usage:    "get, set or rewind the cursor of a runner in the state store",
+				Category: "MISC",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "get",
+						Usage:       "get the cursor of the runner",
+						Subcommands: cursorCommands("get the cursor of %s", "", cursorGet),
+					},
+					{
+						Name:        "set",
+						Usage:       "set the cursor of the runner while holding its running lock",
+						Subcommands: cursorCommands("set the cursor of %s", "CURSOR", cursorSet),
+					},
+					{
+						Name:        "rewind",
+						Usage:       "rewind the cursor of the runner while holding its running lock",
+						Subcommands: cursorCommands("rewind the cursor of %s", "COUNT", cursorRewind),
+					},
+				},
+			},
 			{
 				Name:     "xmaintain",
 				Aliases:  []string{"xm"},
@@ -109,9 +178,15 @@ func main() {
 	go func() {
 		defer cancel()
 		sigs := make(chan os.Signal, 1)
-		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-		ss := <-sigs
-		log.Errorf("GOT A SYSTEM SIGNAL[%s]", ss.String())
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for ss := range sigs {
+			if ss == syscall.SIGHUP {
+				log.Warnf("GOT A SYSTEM SIGNAL[%s], reload config of %d runners", ss.String(), configReloads.notify())
+				continue
+			}
+			log.Errorf("GOT A SYSTEM SIGNAL[%s]", ss.String())
+			return
+		}
 	}()
 	if err := app.RunContext(baseCtx, os.Args); err != nil {
 		log.Error(err)
@@ -137,38 +212,32 @@ func checkerror(err error) error {
 	}
 }
 
+func runOfType(ctx *cli.Context, runnerType string) error {
+	h, err := newRunnerOfType(runnerType)
+	if err != nil {
+		return checkerror(cli.Exit(err, ExitByInput))
+	}
+	return checkerror(h.base().run(ctx))
+}
+
 func sync(ctx *cli.Context) error {
-	a := &syncer{}
-	a.bHandler = a
-	a.lHander = a
-	return checkerror(a.run(ctx))
+	return runOfType(ctx, "sync")
 }
 
 func maintain(ctx *cli.Context) error {
-	a := &maintainer{}
-	a.bHandler = a
-	a.lHander = a
-	return checkerror(a.run(ctx))
+	return runOfType(ctx, "maintain")
 }
 
 func update(ctx *cli.Context) error {
-	a := &updater{}
-	a.bHandler = a
-	return checkerror(a.run(ctx))
+	return runOfType(ctx, "update")
 }
 
 func xmaintain(ctx *cli.Context) error {
-	a := &xmaintainer{}
-	a.bHandler = a
-	a.lHander = a
-	return checkerror(a.run(ctx))
+	return runOfType(ctx, "xmaintain")
 }
 
 func xsync(ctx *cli.Context) error {
-	a := &xsyncer{}
-	a.bHandler = a
-	a.lHander = a
-	return checkerror(a.run(ctx))
+	return runOfType(ctx, "xsync")
 }
 
 func pemfile(ctx *cli.Context) error {
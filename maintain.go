@@ -15,10 +15,11 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
-	"strings"
+	"time"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/log"
@@ -40,8 +41,8 @@ func (a *maintainer) prepareConfig(ctx *cli.Context) error {
 	if err := a.looper.prepareConfig(ctx); err != nil {
 		return err
 	}
-	a.keys.startHeightKey = fmt.Sprintf("%s_start_%d", strings.ToLower(a.Name()), a.conf.SrcChainId)
-	a.keys.runnerLockKey = fmt.Sprintf("%s_lock_%d", strings.ToLower(a.Name()), a.conf.SrcChainId)
+	a.keys.startHeightKey = a.keyBuilder().startHeight(a.Name(), a.conf.SrcChainId)
+	a.keys.runnerLockKey = a.keyBuilder().runnerLock(a.Name(), a.conf.SrcChainId)
 	log.Infof("%s", a.keys)
 
 	if _, exist := LightNodeABI.Events[updateCommEvent]; !exist {
@@ -60,43 +61,56 @@ func (a *maintainer) prepareConfig(ctx *cli.Context) error {
 	return nil
 }
 
-func (a *maintainer) confirmConfig(ctx *cli.Context) error {
-	if err := a.looper.confirmConfig(ctx); err != nil {
-		return err
-	}
-
-	// check availability of light-node and get lastHeight
-	lastHeight := common.NilHeight
+// _lastHeight returns the lastHeight of the light-node
+func (a *maintainer) _lastHeight(ctx context.Context) (common.Height, error) {
 	input, err := LightNodeABI.Pack(lastHeightName)
 	if err != nil {
-		return fmt.Errorf("encode lightnode.lastHeight() failed: %w", err)
+		return common.NilHeight, fmt.Errorf("encode lightnode.lastHeight() failed: %w", err)
 	}
-	output, err := a.target.callContract(ctx.Context, a.targetPriv.Address(), &a.conf.Maintainer.TargetLCAddr, defaultGas, nil, nil, input)
+	output, err := a.target.callContract(ctx, a.targetPriv.Address(), &a.conf.Maintainer.TargetLCAddr, defaultGas, nil, nil, input)
 	if err != nil {
-		return fmt.Errorf("lightnode.lastHeight() failed: %w", err)
+		return common.NilHeight, fmt.Errorf("lightnode.lastHeight() failed: %w", err)
 	}
 	outobj := new(struct {
 		Height uint64
 	})
 	if err = LightNodeABI.UnpackReturns(outobj, lastHeightName, output); err != nil {
-		return fmt.Errorf("parse lastHeight failed: %w", err)
+		return common.NilHeight, fmt.Errorf("parse lastHeight failed: %w", err)
 	}
-	lastHeight = common.Height(outobj.Height)
+	lastHeight := common.Height(outobj.Height)
 	log.Infof("lastHeight of light-node: %s", &lastHeight)
+	a._observeHeight(lnHeightGauge, lastHeight)
+	return lastHeight, nil
+}
+
+func (a *maintainer) confirmConfig(ctx *cli.Context) error {
+	if err := a.looper.confirmConfig(ctx); err != nil {
+		return err
+	}
+
+	// check availability of light-node and get lastHeight
+	lastHeight, err := a._lastHeight(ctx.Context)
+	if err != nil {
+		return err
+	}
 
 	// update lastHeight
-	newHeight := lastHeight + 1
+	minStart, maxStart := cursorRange(lastHeight)
 	startHeight := a.getStartHeight(ctx)
-	diff, cmp := startHeight.Diff(newHeight)
-	if cmp < 0 {
-		log.Warnf("replace start height from:%s to light-node.lastHeight+1: %s", &startHeight, &newHeight)
-		if err := a.updateStartHeight(ctx, newHeight); err != nil {
-			return fmt.Errorf("update start height (%d) failed: %w", newHeight, err)
+	if startHeight.Compare(minStart) < 0 {
+		log.Warnf("replace start height from:%s to light-node.lastHeight+1: %s", &startHeight, &minStart)
+		if err := a.updateStartHeight(ctx, minStart); err != nil {
+			return fmt.Errorf("update start height (%d) failed: %w", minStart, err)
 		}
-	} else if cmp > 0 {
-		if diff >= common.BlocksInEpoch {
+	} else if startHeight.Compare(maxStart) > 0 {
+		if !a.conf.DryRun {
 			return fmt.Errorf("startHeight:%s but light-node.lastHeight:%s", &startHeight, &lastHeight)
 		}
+		// the light node is never updated by a dry-run
+		log.Warnf("DRY-RUN: rewind start height from:%s to light-node.lastHeight+1: %s", &startHeight, &minStart)
+		if err := a.updateStartHeight(ctx, minStart); err != nil {
+			return fmt.Errorf("update start height (%d) failed: %w", minStart, err)
+		}
 	}
 	log.Infof("start height: %d", a.getStartHeight(ctx))
 
@@ -160,7 +174,7 @@ func (a *maintainer) processBlock(cctx *cli.Context, block *models.BlockEMessage
 	return nil, nil
 }
 
-func (a *maintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof) error {
+func (a *maintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof) (errr error) {
 	if err := comm.Verify(true); err != nil {
 		return err
 	}
@@ -172,6 +186,9 @@ func (a *maintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof)
 	defer func() {
 		_ = a.sendingLock.Release()
 	}()
+	keeper := keepLease(cctx.Context, a.sendingLock)
+	defer keeper.stop(&errr)
+	cctx = withContext(cctx, keeper.Context())
 
 	proof := comm.ForABI()
 	data, err := LightNodeABI.Methods[updateNextCommName].Inputs.Pack(proof)
@@ -183,30 +200,37 @@ func (a *maintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof)
 		return fmt.Errorf("packinput failed: %w", err)
 	}
 	to := a.conf.Maintainer.TargetLCAddr
+	if a.conf.DryRun {
+		return a._simulateTx(cctx.Context, &to, input, fmt.Sprintf("Epoch:%d", comm.Header.Height.EpochNum()+1))
+	}
 
-	// gas, err := a.target.estimateGas(id.Address(), &to, 30000000, nil, nil, input)
-	// if err != nil {
-	// 	return fmt.Errorf("estimate failed: %w", err)
-	// }
-	gas, mustHave := a._targetSuggestBalance(cctx.Context)
+	gas, err := a._targetEstimateGas(cctx.Context, &to, input)
+	if err != nil {
+		return fmt.Errorf("estimate failed: %w", err)
+	}
+	mustHave := a._targetSuggestBalance(cctx.Context, gas)
 	nonce, err := a.target.nonceWithBalanceMoreThan(cctx.Context, a.targetPriv.Address(), a.conf.TargetCheckBalance, mustHave)
 	if err != nil {
 		return err
 	}
 
-	ethtx, txhash, err := a.target.sendLegacyTx(cctx.Context, a.targetPriv.Priv(), &to, nonce, gas, nil, nil, input)
+	jctx := putTxJournal(putDistributedLock(cctx.Context, storeLocks{a.runningLock, a.sendingLock}), a.journal, fmt.Sprintf("Epoch:%d", comm.Header.Height.EpochNum()+1))
+	ethtx, txhash, err := a.target.sendTx(jctx, a.targetPriv.Priv(), &to, nonce, gas, nil, input)
 	if err != nil {
 		return fmt.Errorf("send tx failed: %w", err)
 	}
 
 	log.Infof("update comm TxHash: %x", common.ForPrint(txhash, 0))
-	rcpt, err := a.target.checkReceipt(putDistributedLock(cctx.Context, redisLocks{a.runningLock, a.sendingLock}), ethtx)
+	waitStart := time.Now()
+	rcpt, err := a.target.checkReceipt(jctx, a.targetPriv.Priv(), ethtx)
 	if err != nil {
 		return fmt.Errorf("get receipt failed: %w", err)
 	}
+	a._observeReceiptWait(waitStart)
+	a._observeGasUsed(rcpt.GasUsed)
 	log.Debugf("%s", rcpt.InfoString(0))
 	if !rcpt.Success() {
-		return fmt.Errorf("tx failed: %w", rcpt.Err())
+		return fmt.Errorf("tx %x failed: %s", rcpt.TxHash[:], a._failedReason(cctx.Context, ethtx, rcpt))
 	}
 	updateEvent := LightNodeABI.Events[updateCommEvent]
 	for _, l := range rcpt.Logs {
@@ -241,44 +265,57 @@ func (a *maintainer) _parseLNUpdateEvent(l *models.Log) (*updateEvent, error) {
 	}, nil
 }
 
-func (a *maintainer) _checkOneLatestEpoch(ctx *cli.Context, index int64) error {
+// _latestEpochComm returns the epoch at latest2Epoch[index] and its committee in light-node, a
+// NilEpoch returns if it is not set yet
+func (a *maintainer) _latestEpochComm(ctx context.Context, index int64) (common.EpochNum, []common.Address, error) {
 	from := a.targetPriv.Address()
 	to := a.conf.Maintainer.TargetLCAddr
 
 	latestObj := new(struct{ Epoch uint64 })
 	latestObj.Epoch = uint64(common.NilEpoch)
-	if err := a.target.getter(ctx.Context, from, &to,
+	if err := a.target.getter(ctx, from, &to,
 		LightNodeABI.Methods[latest2EpochName], latestObj, big.NewInt(index)); err != nil {
-		return fmt.Errorf("%s[index:%d] failed: %w", latest2EpochName, index, err)
+		return common.NilEpoch, nil, fmt.Errorf("%s[index:%d] failed: %w", latest2EpochName, index, err)
 	}
 	if common.EpochNum(latestObj.Epoch).IsNil() {
-		log.Warnf("target.%s[%d] not set", latest2EpochName, index)
-		return nil
+		return common.NilEpoch, nil, nil
 	}
 
 	commObj := new(struct{ Comms []common.Address })
-	if err := a.target.getter(ctx.Context, from, &to,
+	if err := a.target.getter(ctx, from, &to,
 		LightNodeABI.Methods[checkEpochCommName], commObj, latestObj.Epoch); err != nil {
-		return fmt.Errorf("%s[index:%d] -> %s(epoch:%d) failed: %w", latest2EpochName, index,
+		return common.NilEpoch, nil, fmt.Errorf("%s[index:%d] -> %s(epoch:%d) failed: %w", latest2EpochName, index,
 			checkEpochCommName, latestObj.Epoch, err)
 	}
 	if len(commObj.Comms) == 0 {
-		return fmt.Errorf("%s(index:%d) -> %s(epoch:%d) got nothing", latest2EpochName, index,
+		return common.NilEpoch, nil, fmt.Errorf("%s(index:%d) -> %s(epoch:%d) got nothing", latest2EpochName, index,
 			checkEpochCommName, latestObj.Epoch)
 	}
+	return common.EpochNum(latestObj.Epoch), commObj.Comms, nil
+}
+
+func (a *maintainer) _checkOneLatestEpoch(ctx *cli.Context, index int64) error {
+	epoch, comms, err := a._latestEpochComm(ctx.Context, index)
+	if err != nil {
+		return err
+	}
+	if epoch.IsNil() {
+		log.Warnf("target.%s[%d] not set", latest2EpochName, index)
+		return nil
+	}
 
-	srcComm, err := getSourceCommOfEpoch(ctx.Context, a.src, common.EpochNum(latestObj.Epoch))
+	srcComm, err := getSourceCommOfEpoch(ctx.Context, a.src, epoch)
 	if err != nil {
-		return fmt.Errorf("src.Committee(epoch:%d) failed: %w", latestObj.Epoch, err)
+		return fmt.Errorf("src.Committee(epoch:%d) failed: %w", epoch, err)
 	}
 
-	if !committeeEquals(srcComm, commObj.Comms) {
+	if !committeeEquals(srcComm, comms) {
 		return fmt.Errorf("%s[index:%d]=epoch:%d addrs(%s) not match with %s",
-			latest2EpochName, index, latestObj.Epoch, commObj.Comms, srcComm)
+			latest2EpochName, index, epoch, comms, srcComm)
 	}
 
 	log.Infof("%s[index:%d]=epoch:%d\naddrs:%s matchs:%s",
-		latest2EpochName, index, latestObj.Epoch, common.IndentLevel(0).InfoString(commObj.Comms), srcComm.InfoString(0))
+		latest2EpochName, index, epoch, common.IndentLevel(0).InfoString(comms), srcComm.InfoString(0))
 	return nil
 }
 
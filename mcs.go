@@ -17,6 +17,7 @@ package main
 import (
 	"fmt"
 	"math/big"
+	sc "sync"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/abi"
@@ -1988,9 +1989,13 @@ const (
 	orderListName    = "orderList"
 )
 
+var mcsAbisOnce sc.Once
+
 func initMCSAbis() {
-	MCSAbi = *abi.MustInitAbi("MCS", McsAbi)
-	MCSRelayAbi = *abi.MustInitAbi("MCS Relay", McsRelayAbi)
+	mcsAbisOnce.Do(func() {
+		MCSAbi = *abi.MustInitAbi("MCS", McsAbi)
+		MCSRelayAbi = *abi.MustInitAbi("MCS Relay", McsRelayAbi)
+	})
 }
 
 type MapTransferOutLog struct {
@@ -0,0 +1,216 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	metricsNamespace = "lcagent"
+
+	lockRunning = "running"
+	lockSending = "sending"
+
+	transferFound   = "found"
+	transferRelayed = "relayed"
+	transferFailed  = "failed"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	// every metric is labeled by the name of runner and the target chain id
+	metricLabelNames = []string{"runner", "chain"}
+
+	sourceHeadGauge = newGaugeVec("source_head_height", "current height of the source chain")
+	cursorGauge     = newGaugeVec("start_height", "start height of the next loop of the looper")
+	lnHeightGauge   = newGaugeVec("lightnode_last_height", "lastHeight of the light node on target chain")
+	lnEpochGauge    = newGaugeVec("lightnode_last_epoch", "lastEpoch of the light node on target chain")
+	balanceGauge    = newGaugeVec("sender_balance_wei", "balance of the sender on target chain")
+
+	provableCacheCounter = newCounterVec("provable_height_cache_total", "lookups of the provable height cache", "result")
+	transfersCounter     = newCounterVec("transfers_total", "cross-chain transfers found, relayed and failed", "state")
+	gasUsedCounter       = newCounterVec("tx_gas_used_total", "gas used by txs sent to target chain")
+	lockFailuresCounter  = newCounterVec("lock_failures_total", "failures of fetching or refreshing locks", "lock", "op")
+	prefilterCounter     = newCounterVec("prefilter_skipped_total", "contract calls skipped by the prefilter without fetching proofs")
+
+	receiptWaitHistogram = func() *prometheus.HistogramVec {
+		h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "receipt_wait_seconds",
+			Help:      "time waiting for the receipts of txs sent to target chain",
+			Buckets:   []float64{5, 10, 20, 30, 60, 120, 300, 600},
+		}, metricLabelNames)
+		metricsRegistry.MustRegister(h)
+		return h
+	}()
+)
+
+func init() {
+	metricsRegistry.MustRegister(prometheus.NewGoCollector(), prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+}
+
+func newGaugeVec(name, help string, labels ...string) *prometheus.GaugeVec {
+	g := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      name,
+		Help:      help,
+	}, append(append([]string{}, metricLabelNames...), labels...))
+	metricsRegistry.MustRegister(g)
+	return g
+}
+
+func newCounterVec(name, help string, labels ...string) *prometheus.CounterVec {
+	c := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      name,
+		Help:      help,
+	}, append(append([]string{}, metricLabelNames...), labels...))
+	metricsRegistry.MustRegister(c)
+	return c
+}
+
+func bigToFloat(i *big.Int) float64 {
+	if i == nil {
+		return 0
+	}
+	f, _ := new(big.Float).SetInt(i).Float64()
+	return f
+}
+
+// httpServer serves /metrics, /healthz and /readyz of all the runners in the process
+type httpServer struct {
+	server *http.Server
+	mux    *http.ServeMux
+}
+
+func newHTTPServer(addr string) *httpServer {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{Registry: metricsRegistry}))
+	mux.Handle("/healthz", runnerHealth.handler(healthLive))
+	mux.Handle("/readyz", runnerHealth.handler(healthReady))
+	return &httpServer{
+		server: &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second},
+		mux:    mux,
+	}
+}
+
+func (s *httpServer) String() string {
+	if s == nil {
+		return "HTTP<nil>"
+	}
+	return "HTTP@" + s.server.Addr
+}
+
+func (s *httpServer) start() error {
+	ln, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("%s listen failed: %w", s, err)
+	}
+	log.Infof("%s listening", s)
+	go func() {
+		if err := s.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Errorf("%s failed: %v", s, err)
+		}
+	}()
+	return nil
+}
+
+func (s *httpServer) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		log.Warnf("%s shutdown failed: %v", s, err)
+	}
+}
+
+// _metricLabels returns the label values of the runner, followed by extras
+func (a *runner) _metricLabels(extras ...string) []string {
+	name := a.Name()
+	if a.bHandler != nil {
+		name = a.bHandler.Name()
+	}
+	chain := ""
+	if a.conf != nil && a.conf.TargetChainID != nil {
+		chain = a.conf.TargetChainID.String()
+	}
+	return append([]string{name, chain}, extras...)
+}
+
+func (a *runner) _observeHeight(gauge *prometheus.GaugeVec, height common.Height) {
+	if height.IsNil() {
+		return
+	}
+	gauge.WithLabelValues(a._metricLabels()...).Set(float64(height))
+}
+
+func (a *runner) _observeEpoch(epoch common.EpochNum) {
+	if epoch.IsNil() {
+		return
+	}
+	lnEpochGauge.WithLabelValues(a._metricLabels()...).Set(float64(epoch))
+}
+
+func (a *runner) _observeCache(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	provableCacheCounter.WithLabelValues(a._metricLabels(result)...).Inc()
+}
+
+func (a *runner) _observeTransfers(state string, count int) {
+	if count <= 0 {
+		return
+	}
+	transfersCounter.WithLabelValues(a._metricLabels(state)...).Add(float64(count))
+}
+
+func (a *runner) _observeGasUsed(gasUsed uint64) {
+	gasUsedCounter.WithLabelValues(a._metricLabels()...).Add(float64(gasUsed))
+}
+
+func (a *runner) _observeSkipped(count int) {
+	prefilterCounter.WithLabelValues(a._metricLabels()...).Add(float64(count))
+}
+
+func (a *runner) _observeReceiptWait(since time.Time) {
+	receiptWaitHistogram.WithLabelValues(a._metricLabels()...).Observe(time.Since(since).Seconds())
+}
+
+// _observeBalance updates the balance of the sender, only if metrics are exported
+func (a *runner) _observeBalance(ctx context.Context) {
+	if a.http == nil || a.target == nil || a.targetPriv == nil {
+		return
+	}
+	balance, err := a.target.getBalance(ctx, a.targetPriv.Address())
+	if err != nil {
+		log.Debugf("get balance of sender failed: %v", err)
+		return
+	}
+	balanceGauge.WithLabelValues(a._metricLabels()...).Set(bigToFloat(balance))
+}
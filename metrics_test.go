@@ -0,0 +1,54 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRunnerMetrics(t *testing.T) {
+	a := &runner{conf: &Config{TargetName: "METRICS", TargetChainID: big.NewInt(97)}}
+	labels := a._metricLabels()
+	if len(labels) != 2 || labels[0] != "RUNNER_METRICS" || labels[1] != "97" {
+		t.Fatalf("invalid labels: %v", labels)
+	}
+
+	a._observeHeight(cursorGauge, common.Height(100))
+	a._observeHeight(cursorGauge, common.NilHeight)
+	if v := testutil.ToFloat64(cursorGauge.WithLabelValues(labels...)); v != 100 {
+		t.Fatalf("cursor want:100 got:%f", v)
+	}
+
+	a._observeTransfers(transferRelayed, 3)
+	a._observeTransfers(transferRelayed, 0)
+	if v := testutil.ToFloat64(transfersCounter.WithLabelValues(a._metricLabels(transferRelayed)...)); v != 3 {
+		t.Fatalf("relayed want:3 got:%f", v)
+	}
+
+	a._observeCache(true)
+	a._observeCache(false)
+	a._observeCache(true)
+	if v := testutil.ToFloat64(provableCacheCounter.WithLabelValues(a._metricLabels("hit")...)); v != 2 {
+		t.Fatalf("cache hit want:2 got:%f", v)
+	}
+
+	if n, err := testutil.GatherAndCount(metricsRegistry, "lcagent_start_height", "lcagent_transfers_total"); err != nil || n != 2 {
+		t.Fatalf("gather failed: %d %v", n, err)
+	}
+}
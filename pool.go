@@ -0,0 +1,95 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	sc "sync"
+)
+
+// _fetchRecovered calls fetch, a panic is returned as an error, for it could not be recovered by
+// the caller in another goroutine
+func _fetchRecovered[T any](ctx context.Context, i int, fetch func(ctx context.Context, i int) (T, error)) (result T, errr error) {
+	defer func() {
+		if p := recover(); p != nil {
+			errr = fmt.Errorf("fetch %d panic: %v", i, p)
+		}
+	}()
+	return fetch(ctx, i)
+}
+
+// fetchOrdered calls fetch for the indexes in [0, n) with at most concurrency workers, and returns
+// the results in the order of the indexes. Once a fetch fails, the others are cancelled and the
+// first error is returned.
+func fetchOrdered[T any](ctx context.Context, concurrency, n int,
+	fetch func(ctx context.Context, i int) (T, error)) ([]T, error) {
+	results := make([]T, n)
+	if n == 0 {
+		return results, nil
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+	wctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		lock  sc.Mutex
+		first error
+		wg    sc.WaitGroup
+	)
+	indexes := make(chan int)
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				result, err := _fetchRecovered(wctx, i, fetch)
+				if err != nil {
+					lock.Lock()
+					if first == nil {
+						first = err
+					}
+					lock.Unlock()
+					cancel()
+					continue
+				}
+				results[i] = result
+			}
+		}()
+	}
+feed:
+	for i := 0; i < n; i++ {
+		select {
+		case indexes <- i:
+		case <-wctx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	if first != nil {
+		return nil, first
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchOrdered(t *testing.T) {
+	var running, maxRunning int64
+	results, err := fetchOrdered(context.Background(), 3, 20, func(ctx context.Context, i int) (int, error) {
+		n := atomic.AddInt64(&running, 1)
+		defer atomic.AddInt64(&running, -1)
+		for {
+			m := atomic.LoadInt64(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt64(&maxRunning, m, n) {
+				break
+			}
+		}
+		// the later ones finish earlier
+		time.Sleep(time.Duration(20-i) * time.Millisecond)
+		return i * i, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if r != i*i {
+			t.Fatalf("results out of order: %v", results)
+		}
+	}
+	if maxRunning > 3 {
+		t.Fatalf("concurrency exceeded: %d", maxRunning)
+	}
+	t.Logf("max running: %d", maxRunning)
+
+	failure := errors.New("failure")
+	var fetched int64
+	_, err = fetchOrdered(context.Background(), 2, 100, func(ctx context.Context, i int) (int, error) {
+		atomic.AddInt64(&fetched, 1)
+		if i == 3 {
+			return 0, failure
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(5 * time.Millisecond):
+			return i, nil
+		}
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("want the first error, got: %v", err)
+	}
+	if fetched >= 100 {
+		t.Fatal("the remaining fetches should be cancelled")
+	}
+
+	_, err = fetchOrdered(context.Background(), 2, 10, func(ctx context.Context, i int) (int, error) {
+		if i == 5 {
+			var m map[int]int
+			m[i] = i
+		}
+		return i, nil
+	})
+	if err == nil {
+		t.Fatal("the panic should be returned as an error")
+	}
+	t.Log(err)
+}
@@ -0,0 +1,156 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ThinkiumGroup/go-tkmrpc/client"
+	"github.com/urfave/cli/v2"
+)
+
+// nextStartOf returns the height after the last block of the batch
+func nextStartOf(blocks *client.RpcBlocks) (common.Height, bool) {
+	if blocks == nil {
+		return common.NilHeight, false
+	}
+	for i := len(blocks.Blocks) - 1; i >= 0; i-- {
+		if b := blocks.Blocks[i]; b != nil && b.BlockHeader != nil {
+			return b.GetHeight() + 1, true
+		}
+	}
+	return common.NilHeight, false
+}
+
+type prefetched struct {
+	start  common.Height
+	blocks *client.RpcBlocks
+	err    error
+}
+
+// prefetcher fetches the following batches of blocks while the current one is processing, at
+// most depth batches are kept ahead. The fetched batches are discarded once the start height
+// asked is not the one expected, and all of them go with the context of the lease.
+type prefetcher struct {
+	a       *looper
+	depth   int
+	cancel  context.CancelFunc
+	batches <-chan *prefetched
+}
+
+func newPrefetcher(a *looper, depth int) *prefetcher {
+	return &prefetcher{a: a, depth: depth}
+}
+
+func (p *prefetcher) _start(cctx *cli.Context, start common.Height) {
+	ctx, cancel := context.WithCancel(cctx.Context)
+	batches := make(chan *prefetched, p.depth-1)
+	p.cancel, p.batches = cancel, batches
+	go p._run(withContext(cctx, ctx), p.a.src, start, batches)
+}
+
+func (p *prefetcher) _run(cctx *cli.Context, src *sourcePool, start common.Height, out chan<- *prefetched) {
+	defer close(out)
+	for p.a.lHander.prefetchable(cctx, start) {
+		blocks, err := src.Blocks(cctx.Context, start)
+		select {
+		case out <- &prefetched{start: start, blocks: blocks, err: err}:
+		case <-cctx.Done():
+			return
+		}
+		next, ok := nextStartOf(blocks)
+		if err != nil || !ok || next.Compare(blocks.Current) > 0 {
+			return
+		}
+		start = next
+	}
+}
+
+func (p *prefetcher) stop() {
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel, p.batches = nil, nil
+	}
+}
+
+// blocks returns the batch at start, which is prefetched if possible. The limits of the looper
+// handler are always checked by prepareToGet before the batch returns.
+func (p *prefetcher) blocks(cctx *cli.Context, start common.Height) (*client.RpcBlocks, error) {
+	if p.batches != nil {
+		select {
+		case got, ok := <-p.batches:
+			if ok && got.start == start {
+				if err := p.a.lHander.prepareToGet(cctx, start); err != nil {
+					p.stop()
+					return nil, err
+				}
+				if got.err != nil {
+					p.stop()
+					return nil, got.err
+				}
+				return p.a._gotBlocks(start, got.blocks), nil
+			}
+			if ok {
+				log.Debugf("prefetched batch at %s discarded, want %s", &got.start, &start)
+			}
+			p.stop()
+		case <-cctx.Done():
+			p.stop()
+			return nil, cli.Exit(cctx.Err(), ExitByContext)
+		}
+	}
+	blocks, err := p.a._tkmBlocks(cctx, start)
+	if err != nil || blocks == nil {
+		return blocks, err
+	}
+	if next, ok := nextStartOf(blocks); ok && next.Compare(blocks.Current) <= 0 {
+		p._start(cctx, next)
+	}
+	return blocks, nil
+}
+
+func (a *looper) prefetchable(_ *cli.Context, _ common.Height) bool {
+	return true
+}
+
+func (a *maintainer) prefetchable(_ *cli.Context, start common.Height) bool {
+	if !a.conf.SrcIgnoreBlocks {
+		return true
+	}
+	_, ignored := shouldIgnoreHeight(start)
+	return !ignored
+}
+
+func (a *xmaintainer) prefetchable(_ *cli.Context, start common.Height) bool {
+	if !a.conf.SrcIgnoreBlocks {
+		return true
+	}
+	_, ignored := shouldIgnoreHeight(start)
+	return !ignored
+}
+
+// prefetchable of the syncers only reads the cached provable heights, the prefetching stops once
+// the cache expired
+func (n *syncer) prefetchable(_ *cli.Context, start common.Height) bool {
+	max, exist := n.maxProvableHeights.Get()
+	return exist && max != nil && start.Compare(max.sub) <= 0
+}
+
+func (n *xsyncer) prefetchable(_ *cli.Context, start common.Height) bool {
+	max, exist := n.maxProvableHeight.Get()
+	return exist && max != nil && start.Compare(*max) <= 0
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-tkmrpc/client"
+	"github.com/ThinkiumGroup/go-tkmrpc/models"
+	"github.com/urfave/cli/v2"
+)
+
+type limitedLooper struct {
+	*looper
+	limit common.Height
+}
+
+var errLimited = errors.New("limited")
+
+func (l *limitedLooper) prepareToGet(_ *cli.Context, start common.Height) error {
+	if start.Compare(l.limit) > 0 {
+		return errLimited
+	}
+	return nil
+}
+
+func testBatch(current common.Height, heights ...common.Height) *client.RpcBlocks {
+	blocks := &client.RpcBlocks{Current: current}
+	for _, h := range heights {
+		blocks.Blocks = append(blocks.Blocks, &models.BlockEMessage{BlockHeader: &models.BlockHeader{Height: h}})
+	}
+	return blocks
+}
+
+func TestNextStartOf(t *testing.T) {
+	if _, ok := nextStartOf(nil); ok {
+		t.Fatal("no next start of nil")
+	}
+	if _, ok := nextStartOf(testBatch(100)); ok {
+		t.Fatal("no next start of empty batch")
+	}
+	if next, ok := nextStartOf(testBatch(100, 10, 11, 12)); !ok || next != 13 {
+		t.Fatalf("next start want:13 got:%d %t", next, ok)
+	}
+}
+
+func TestPrefetcherBlocks(t *testing.T) {
+	a := &looper{runner: runner{conf: &Config{TargetName: "PREFETCH"}}}
+	a.lHander = &limitedLooper{looper: a, limit: 20}
+	defer sourceHeadGauge.DeleteLabelValues(a._metricLabels()...)
+	cctx := &cli.Context{Context: context.Background()}
+
+	feed := func(p *prefetcher, batches ...*prefetched) {
+		ch := make(chan *prefetched, len(batches))
+		for _, b := range batches {
+			ch <- b
+		}
+		close(ch)
+		_, p.cancel = context.WithCancel(context.Background())
+		p.batches = ch
+	}
+
+	p := newPrefetcher(a, 2)
+	feed(p, &prefetched{start: 10, blocks: testBatch(100, 10, 11)})
+	blocks, err := p.blocks(cctx, 10)
+	if err != nil || blocks == nil || len(blocks.Blocks) != 2 {
+		t.Fatalf("prefetched batch expected, got:%v %v", blocks, err)
+	}
+
+	// rewound: the prefetched batch is discarded and the batch is fetched again
+	feed(p, &prefetched{start: 12, blocks: testBatch(100, 12, 13)})
+	if _, err := p.blocks(cctx, 30); !errors.Is(err, errLimited) {
+		t.Fatalf("limited fetching expected, got:%v", err)
+	}
+	if p.batches != nil {
+		t.Fatal("prefetching should be stopped")
+	}
+
+	// the limits are checked even if the batch was prefetched
+	feed(p, &prefetched{start: 21, blocks: testBatch(100, 21, 22)})
+	if _, err := p.blocks(cctx, 21); !errors.Is(err, errLimited) {
+		t.Fatalf("limited prefetched batch expected, got:%v", err)
+	}
+	if p.batches != nil {
+		t.Fatal("prefetching should be stopped")
+	}
+}
+
+func TestSyncerPrefetchable(t *testing.T) {
+	n := &syncer{}
+	n.conf = &Config{TargetName: "PREFETCH"}
+	n.maxProvableHeights = NewExpirable[*provableHeights](nil, 60000, 0)
+	cctx := &cli.Context{Context: context.Background()}
+	// the source and the target would be used if the cache was missed
+	if n.prefetchable(cctx, 10) {
+		t.Fatal("not prefetchable without the cached heights")
+	}
+	n.maxProvableHeights.Update(&provableHeights{main: 30, sub: 20})
+	if !n.prefetchable(cctx, 20) || n.prefetchable(cctx, 21) {
+		t.Fatal("prefetchable until the cached sub height")
+	}
+
+	x := &xsyncer{}
+	x.conf = &Config{TargetName: "PREFETCH"}
+	x.maxProvableHeight = NewExpirable[*common.Height](nil, 60000, 0)
+	if x.prefetchable(cctx, 10) {
+		t.Fatal("not prefetchable without the cached height")
+	}
+	max := common.Height(20)
+	x.maxProvableHeight.Update(&max)
+	if !x.prefetchable(cctx, 20) || x.prefetchable(cctx, 21) {
+		t.Fatal("prefetchable until the cached height")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ThinkiumGroup/go-tkmrpc/models"
+)
+
+const (
+	prefilterReceipt = "receipt" // the receipt has the watched event of the MCS
+	prefilterTo      = "to"      // the tx calls the MCS or one of the routers
+	prefilterNone    = "none"    // all the contract calls
+)
+
+// txFilter selects the txs of a block which may emit the watched event, before their proofs are
+// fetched. The txs calling the MCS or the routers are always selected.
+type txFilter struct {
+	mode    string
+	mcs     common.Address
+	topicId common.Hash
+	targets map[common.Address]bool
+}
+
+func newTxFilter(mode string, mcs common.Address, topicId common.Hash, routers []common.Address) (*txFilter, error) {
+	switch mode {
+	case prefilterReceipt, prefilterTo, prefilterNone:
+	default:
+		return nil, fmt.Errorf("unknown prefilter mode: %q", mode)
+	}
+	targets := map[common.Address]bool{mcs: true}
+	for _, router := range routers {
+		targets[router] = true
+	}
+	return &txFilter{mode: mode, mcs: mcs, topicId: topicId, targets: targets}, nil
+}
+
+func (f *txFilter) String() string {
+	if f == nil {
+		return "TxFilter<nil>"
+	}
+	return fmt.Sprintf("TxFilter{%s MCS:%x Targets:%d}", f.mode, f.mcs[:], len(f.targets))
+}
+
+// candidates returns the selected contract calls in txs in their original order, and the number
+// of the skipped ones. In receipt mode, the receipts are queried with at most concurrency requests.
+func (f *txFilter) candidates(ctx context.Context, src *sourcePool, concurrency int,
+	txs []*models.Transaction) (candidates []*models.Transaction, skipped int, err error) {
+	var calls []*models.Transaction
+	for _, tx := range txs {
+		if tx.To != nil && len(tx.Input) > 0 {
+			calls = append(calls, tx)
+		}
+	}
+	if f.mode == prefilterNone || len(calls) == 0 {
+		return calls, 0, nil
+	}
+	matches, err := fetchOrdered(ctx, concurrency, len(calls), func(ctx context.Context, i int) (bool, error) {
+		tx := calls[i]
+		if f.targets[*tx.To] {
+			return true, nil
+		}
+		if f.mode == prefilterTo {
+			return false, nil
+		}
+		txHash := tx.Hash()
+		rec, err := src.Receipt(ctx, txHash)
+		if err != nil || rec == nil {
+			return false, fmt.Errorf("get receipt of TxHash:%x failed: %w", txHash[:], err)
+		}
+		if rec.Status != models.ReceiptStatusSuccessful {
+			return false, nil
+		}
+		i, _ = locateLog(rec.Logs, f.mcs, f.topicId)
+		return i >= 0, nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, match := range matches {
+		if match {
+			candidates = append(candidates, calls[i])
+		}
+	}
+	return candidates, len(calls) - len(candidates), nil
+}
+
+// _prefilter selects the candidate txs of the block by the filter, and reports the skipped ones
+func (a *looper) _prefilter(ctx context.Context, f *txFilter, block *models.BlockEMessage) ([]*models.Transaction, error) {
+	candidates, skipped, err := f.candidates(ctx, a.src, a.conf.SrcConcurrency, block.BlockBody.Txs)
+	if err != nil {
+		return nil, fmt.Errorf("prefilter failed: %w", err)
+	}
+	if skipped > 0 {
+		log.Debugf("Block:%s %d candidates, %d skipped by %s", &block.BlockHeader.Height, len(candidates), skipped, f)
+		a._observeSkipped(skipped)
+	}
+	return candidates, nil
+}
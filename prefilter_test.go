@@ -0,0 +1,63 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-tkmrpc/models"
+)
+
+func TestTxFilter(t *testing.T) {
+	mcs := common.BytesToAddress([]byte{0x01})
+	router := common.BytesToAddress([]byte{0x02})
+	other := common.BytesToAddress([]byte{0x03})
+	txs := []*models.Transaction{
+		{To: &other, Input: []byte{0x01}, Nonce: 0},
+		{To: &router, Input: []byte{0x01}, Nonce: 1},
+		{To: &mcs, Input: nil, Nonce: 2},
+		{To: nil, Input: []byte{0x01}, Nonce: 3},
+		{To: &mcs, Input: []byte{0x01}, Nonce: 4},
+	}
+
+	if _, err := newTxFilter("bloom", mcs, common.EmptyHash, nil); err == nil {
+		t.Fatal("unknown mode should fail")
+	}
+	f, err := newTxFilter(prefilterTo, mcs, common.EmptyHash, []common.Address{router})
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, skipped, err := f.candidates(context.Background(), nil, 2, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 2 || candidates[0].Nonce != 1 || candidates[1].Nonce != 4 || skipped != 1 {
+		t.Fatalf("invalid candidates: %v, skipped: %d", candidates, skipped)
+	}
+
+	f, err = newTxFilter(prefilterNone, mcs, common.EmptyHash, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates, skipped, err = f.candidates(context.Background(), nil, 2, txs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 3 || skipped != 0 {
+		t.Fatalf("all contract calls expected: %v, skipped: %d", candidates, skipped)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"reflect"
+	"strings"
+	sc "sync"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// reloadHub broadcasts the reloads of the config (SIGHUP) to the running runners
+type reloadHub struct {
+	lock sc.Mutex
+	subs map[chan struct{}]struct{}
+}
+
+var configReloads = &reloadHub{subs: make(map[chan struct{}]struct{})}
+
+func (h *reloadHub) subscribe() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.subs[ch] = struct{}{}
+	return ch, func() {
+		h.lock.Lock()
+		defer h.lock.Unlock()
+		delete(h.subs, ch)
+	}
+}
+
+// notify wakes up all the subscribers without blocking, and returns the number of them
+func (h *reloadHub) notify() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return len(h.subs)
+}
+
+// overlayContext returns a child of cctx in which the flags are set to the values
+func overlayContext(cctx *cli.Context, ctx context.Context, name string, flags map[string]string) (*cli.Context, error) {
+	set := flag.NewFlagSet(name, flag.ContinueOnError)
+	for fname, value := range flags {
+		set.String(fname, "", "")
+		if err := set.Set(fname, value); err != nil {
+			return nil, fmt.Errorf("%s set %s failed: %w", name, fname, err)
+		}
+	}
+	octx := cli.NewContext(cctx.App, set, cctx)
+	octx.Context = ctx
+	return octx, nil
+}
+
+func _flattenYaml(prefix string, node *yaml.Node, out map[string]string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: map expected", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		name, value := prefix+node.Content[i].Value, node.Content[i+1]
+		if value.Kind == yaml.MappingNode {
+			if err := _flattenYaml(name+".", value, out); err != nil {
+				return err
+			}
+			continue
+		}
+		v, err := _scalarOf(value)
+		if err != nil {
+			return fmt.Errorf("%s %w", name, err)
+		}
+		out[name] = v
+	}
+	return nil
+}
+
+// parseYamlFlags reads the flags in the YAML text as altsrc does: the keys of nested maps are
+// joined by dot, and the sequences are joined by comma.
+func parseYamlFlags(data []byte) (map[string]string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parse YAML failed: %w", err)
+	}
+	flags := make(map[string]string)
+	if len(doc.Content) == 0 {
+		return flags, nil
+	}
+	if err := _flattenYaml("", doc.Content[0], flags); err != nil {
+		return nil, err
+	}
+	return flags, nil
+}
+
+// argFlags returns the names of the flags given in the arguments, which take precedence over the
+// YAML file
+func argFlags(args []string, flagss ...[]cli.Flag) map[string]bool {
+	primary := make(map[string]string)
+	for _, flags := range flagss {
+		for _, f := range flags {
+			names := f.Names()
+			for _, name := range names {
+				primary[name] = names[0]
+			}
+		}
+	}
+	set := make(map[string]bool)
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		name := strings.TrimLeft(arg, "-")
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if p, exist := primary[name]; exist {
+			set[p] = true
+		}
+	}
+	return set
+}
+
+// reloadContext re-reads the YAML file of the command, and returns a context with its values on
+// top of cctx. The flags given in the command line and the ones shared by the process are kept.
+func reloadContext(cctx *cli.Context, ctx context.Context) (*cli.Context, error) {
+	path := cctx.String(_confFileFlag.Name)
+	if len(path) == 0 {
+		return nil, fmt.Errorf("%s not set", _confFileFlag.Name)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config failed: %w", err)
+	}
+	flags, err := parseYamlFlags(data)
+	if err != nil {
+		return nil, err
+	}
+	// the values are parsed by the flags, for the accessors of cli.Context ignore parsing errors
+	typed := flag.NewFlagSet("reload", flag.ContinueOnError)
+	for _, f := range joinFlags(_allFlags, _serveFlags) {
+		if typed.Lookup(f.Names()[0]) == nil {
+			if err := f.Apply(typed); err != nil {
+				return nil, err
+			}
+		}
+	}
+	fixed := argFlags(os.Args[1:], _allFlags, _serveFlags)
+	for name := range _flagNames(_processFlags) {
+		fixed[name] = true
+	}
+	for name, value := range flags {
+		if typed.Lookup(name) == nil || fixed[name] {
+			delete(flags, name)
+			continue
+		}
+		if err := typed.Set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+	}
+	return overlayContext(cctx, ctx, "reload", flags)
+}
+
+// configChange is a field of Config changed by a reload
+type configChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func (c configChange) String() string {
+	return fmt.Sprintf("%s: %s -> %s", c.Field, c.Old, c.New)
+}
+
+func _diffValues(path string, old, new reflect.Value, changes []configChange) []configChange {
+	if old.Kind() == reflect.Struct {
+		for i := 0; i < old.NumField(); i++ {
+			name := old.Type().Field(i).Name
+			if len(path) > 0 {
+				name = path + "." + name
+			}
+			changes = _diffValues(name, old.Field(i), new.Field(i), changes)
+		}
+		return changes
+	}
+	o, n := fmt.Sprint(old.Interface()), fmt.Sprint(new.Interface())
+	if o != n {
+		changes = append(changes, configChange{Field: path, Old: o, New: n})
+	}
+	return changes
+}
+
+// diffConfig returns the changed fields, the fields of the nested structs are compared one by one
+func diffConfig(old, new *Config) []configChange {
+	return _diffValues("", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), nil)
+}
+
+// _reloadableFields could be changed by a reload, changes of any other field reject the reload
+var _reloadableFields = map[string]bool{
+	"SrcFetchInterval":           true,
+	"SrcConcurrency":             true,
+	"SrcPrefetch":                true,
+	"TargetRetryInterval":        true,
+	"TargetGPTTL":                true,
+	"TargetCheckBalance":         true,
+	"Synchronizer.MaxHeightTTL":  true,
+	"XSynchronizer.MaxHeightTTL": true,
+}
+
+// configReloader is implemented by the runners holding states derived from the reloadable fields
+type configReloader interface {
+	configReloaded()
+}
+
+func (n *syncer) configReloaded() {
+	n.maxProvableHeights.SetTTL(n.conf.Synchronizer.MaxHeightTTL * 1000)
+}
+
+func (n *xsyncer) configReloaded() {
+	n.maxProvableHeight.SetTTL(n.conf.XSynchronizer.MaxHeightTTL * 1000)
+}
+
+func (a *runner) _applyConfig(conf *Config) {
+	a.conf.SrcFetchInterval = conf.SrcFetchInterval
+	a.conf.SrcConcurrency = conf.SrcConcurrency
+	a.conf.SrcPrefetch = conf.SrcPrefetch
+	a.conf.TargetRetryInterval = conf.TargetRetryInterval
+	a.conf.TargetGPTTL = conf.TargetGPTTL
+	a.conf.TargetCheckBalance = conf.TargetCheckBalance
+	a.conf.Synchronizer.MaxHeightTTL = conf.Synchronizer.MaxHeightTTL
+	a.conf.XSynchronizer.MaxHeightTTL = conf.XSynchronizer.MaxHeightTTL
+	if a.target != nil {
+		a.target.SuggestGasPrice.SetTTL(a.conf.TargetGPTTL * 1000)
+		a.target.RetryInterval = time.Duration(a.conf.TargetRetryInterval) * time.Second
+	}
+	if r, ok := a.bHandler.(configReloader); ok {
+		r.configReloaded()
+	}
+}
+
+// reload validates the new config of the runner by preparing a new runner of the same type with
+// it, and applies the changes if all of them are reloadable.
+func (a *runner) reload(cctx *cli.Context) error {
+	if a.reloadCtx == nil || len(a.runnerType) == 0 {
+		return errors.New("reload not supported")
+	}
+	rctx, err := a.reloadCtx(cctx.Context)
+	if err != nil {
+		return err
+	}
+	// the sender could not be changed, reuse the key instead of reading the PEM again
+	rctx, err = overlayContext(rctx, cctx.Context, "sender",
+		map[string]string{_targetPrivFlag.Name: hex.EncodeToString(a.targetPriv.Priv())})
+	if err != nil {
+		return err
+	}
+	h, err := newRunnerOfType(a.runnerType)
+	if err != nil {
+		return err
+	}
+	if err := h.prepareConfig(rctx); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	conf := h.base().conf
+	if conf.TargetChainID == nil && a.conf.TargetChainID != nil {
+		// not configured, resolved from the target node when connected
+		conf.TargetChainID = new(big.Int).Set(a.conf.TargetChainID)
+	}
+	changes := diffConfig(a.conf, conf)
+	if len(changes) == 0 {
+		log.Infof("%s config not changed", a)
+		return nil
+	}
+	rejected := 0
+	for _, c := range changes {
+		if !_reloadableFields[c.Field] {
+			log.Warnf("%s could not change at runtime: %s", a, c)
+			rejected++
+		}
+	}
+	if rejected > 0 {
+		return fmt.Errorf("%d of %d changes could not be applied at runtime", rejected, len(changes))
+	}
+	a._applyConfig(conf)
+	for _, c := range changes {
+		log.Warnf("%s config reloaded: %s", a, c)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseYamlFlags(t *testing.T) {
+	flags, err := parseYamlFlags([]byte(`
+interval: 5
+target:
+  name: BSC
+  api: [https://a.example, https://b.example]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flags["interval"] != "5" || flags["target.name"] != "BSC" ||
+		flags["target.api"] != "https://a.example,https://b.example" {
+		t.Fatalf("invalid flags: %v", flags)
+	}
+
+	set := argFlags([]string{"sync", "--target.name=BSC", "-f", "a.yaml", "--", "--interval"}, _allFlags, _syncFlags)
+	if !set[_targetNameFlag.Name] || !set[_confFileFlag.Name] || set[_intervalFlag.Name] {
+		t.Fatalf("invalid flags in args: %v", set)
+	}
+}
+
+func TestRunnerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(data string) {
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("interval: 3\nmaintain.targetlc: 0000000000000000000000000000000000000001\n")
+
+	set := flag.NewFlagSet("maintain", flag.ContinueOnError)
+	for _, f := range joinFlags(_allFlags, _maintainFlags) {
+		if err := f.Apply(set); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, value := range map[string]string{
+		_confFileFlag.Name:         path,
+		_targetNameFlag.Name:       "RELOAD",
+		_targetPrivFlag.Name:       "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318",
+		_maintainTargetLCFlag.Name: "0000000000000000000000000000000000000001",
+	} {
+		if err := set.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cctx := cli.NewContext(cli.NewApp(), set, nil)
+	cctx.Context = context.Background()
+
+	h, err := newRunnerOfType("maintain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.prepareConfig(cctx); err != nil {
+		t.Fatal(err)
+	}
+	a := h.base()
+	if err := a.reload(cctx); err == nil {
+		t.Fatal("reload without context should fail")
+	}
+	a.reloadCtx = func(ctx context.Context) (*cli.Context, error) {
+		return reloadContext(cctx, ctx)
+	}
+	if err := a.reload(cctx); err != nil {
+		t.Fatal(err)
+	}
+	if a.conf.SrcFetchInterval != 3 {
+		t.Fatalf("interval want:3 got:%d", a.conf.SrcFetchInterval)
+	}
+
+	// target.chainid not set, but resolved from the target node when connected
+	a.conf.TargetChainID = big.NewInt(97)
+	write("interval: 5\nmaintain.targetlc: 0000000000000000000000000000000000000001\n")
+	if err := a.reload(cctx); err != nil {
+		t.Fatal(err)
+	}
+	if a.conf.SrcFetchInterval != 5 || a.conf.TargetChainID.Int64() != 97 {
+		t.Fatalf("interval:5 chain:97 expected, got:%d %s", a.conf.SrcFetchInterval, a.conf.TargetChainID)
+	}
+
+	write("interval: 7\nmaintain.targetlc: 0000000000000000000000000000000000000002\n")
+	if err := a.reload(cctx); err == nil {
+		t.Fatal("change of the light node should be rejected")
+	} else {
+		t.Log(err)
+	}
+	if a.conf.SrcFetchInterval != 5 {
+		t.Fatalf("rejected reload should not change the interval, got:%d", a.conf.SrcFetchInterval)
+	}
+
+	write("interval: bad\n")
+	if err := a.reload(cctx); err == nil {
+		t.Fatal("invalid config should be rejected")
+	} else {
+		t.Log(err)
+	}
+}
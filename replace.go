@@ -0,0 +1,201 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ThinkiumGroup/go-common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const minBumpPercent = 10 // nodes reject a replacement without at least 10% higher fees
+
+// ReplacePolicy decides when and how a stuck tx is replaced by a new one with the same nonce and
+// payload but higher fees. The fees never exceed the caps in FeePolicy.
+type ReplacePolicy struct {
+	Wait        time.Duration // time to wait for the receipt before replacing, 0 for never
+	BumpPercent uint64        // percentage of fees increased in each replacement
+	MaxBumps    int           // max number of replacements of one tx
+}
+
+func (p *ReplacePolicy) String() string {
+	if p == nil {
+		return "ReplacePolicy<nil>"
+	}
+	return fmt.Sprintf("ReplacePolicy{Wait:%s Bump:%d%% MaxBumps:%d}", p.Wait, p.BumpPercent, p.MaxBumps)
+}
+
+func (p *ReplacePolicy) enabled() bool {
+	return p != nil && p.Wait > 0 && p.MaxBumps > 0
+}
+
+// rounds returns the number of receipt polls at interval, with enough time for all replacements
+// to be mined
+func (p *ReplacePolicy) rounds(base int, interval time.Duration) int {
+	if !p.enabled() || interval <= 0 {
+		return base
+	}
+	perWait := int((p.Wait + interval - 1) / interval)
+	return base + perWait*p.MaxBumps
+}
+
+// bumpFee returns the fee increased by percent, or the suggested one if it is higher, limited by
+// feeCap. Returns false if the result is not enough to replace the old one.
+func bumpFee(old, suggested *big.Int, percent uint64, feeCap *big.Int) (*big.Int, bool) {
+	if old == nil {
+		return nil, false
+	}
+	if percent < minBumpPercent {
+		percent = minBumpPercent
+	}
+	bumped := new(big.Int).Mul(old, new(big.Int).SetUint64(100+percent))
+	// round up, so that small fees are still bumped
+	bumped.Add(bumped, big.NewInt(99))
+	bumped.Div(bumped, big.NewInt(100))
+	if suggested != nil && suggested.Cmp(bumped) > 0 {
+		bumped = new(big.Int).Set(suggested)
+	}
+	if feeCap != nil && bumped.Cmp(feeCap) > 0 {
+		bumped = new(big.Int).Set(feeCap)
+	}
+	least := new(big.Int).Mul(old, big.NewInt(100+minBumpPercent))
+	least.Add(least, big.NewInt(99))
+	least.Div(least, big.NewInt(100))
+	if bumped.Cmp(least) < 0 {
+		return nil, false
+	}
+	return bumped, true
+}
+
+// bumpTx signs and sends a replacement of tx with higher fees
+func (c *EthClient) bumpTx(ctx context.Context, priv []byte, tx *types.Transaction) (*types.Transaction, error) {
+	var percent uint64
+	if c.Replace != nil {
+		percent = c.Replace.BumpPercent
+	}
+	var maxFeeCap, maxTipCap *big.Int
+	if c.Fees != nil {
+		maxFeeCap, maxTipCap = c.Fees.MaxFeeCap, c.Fees.MaxTipCap
+	}
+	to := E2T.AddressP(tx.To())
+	switch tx.Type() {
+	case types.LegacyTxType:
+		suggested, err := c.maxGasPrice(ctx)
+		if err != nil {
+			log.Warnf("suggest gas price failed: %v", err)
+		}
+		gp, ok := bumpFee(tx.GasPrice(), suggested, percent, maxFeeCap)
+		if !ok {
+			return nil, fmt.Errorf("GasPrice:%s reached the cap", math.BigIntForPrint(tx.GasPrice()))
+		}
+		newtx, _, err := c.sendLegacyTx(ctx, priv, to, tx.Nonce(), tx.Gas(), gp, tx.Value(), tx.Data())
+		return newtx, err
+	case types.DynamicFeeTxType:
+		suggestedFee, suggestedTip, err := c.suggestDynamicFees(ctx)
+		if err != nil {
+			log.Warnf("suggest fees failed: %v", err)
+		}
+		feeCap, ok := bumpFee(tx.GasFeeCap(), suggestedFee, percent, maxFeeCap)
+		if !ok {
+			return nil, fmt.Errorf("FeeCap:%s reached the cap", math.BigIntForPrint(tx.GasFeeCap()))
+		}
+		tipCap, ok := bumpFee(tx.GasTipCap(), suggestedTip, percent, maxTipCap)
+		if !ok || tipCap.Cmp(feeCap) > 0 {
+			return nil, fmt.Errorf("TipCap:%s reached the cap", math.BigIntForPrint(tx.GasTipCap()))
+		}
+		newtx, _, err := c.sendDynamicFeeTx(ctx, priv, to, tx.Nonce(), tx.Gas(), feeCap, tipCap, tx.Value(), tx.Data())
+		return newtx, err
+	default:
+		return nil, fmt.Errorf("unsupported tx type: %d", tx.Type())
+	}
+}
+
+// trackedTx is a tx and all its replacements, any of them could be mined
+type trackedTx struct {
+	txs    []*types.Transaction // the original one first
+	sentAt time.Time            // when the latest one was sent
+	capped bool                 // no more replacement
+}
+
+func newTrackedTx(tx *types.Transaction) *trackedTx {
+	return &trackedTx{txs: []*types.Transaction{tx}, sentAt: time.Now()}
+}
+
+func (t *trackedTx) original() *types.Transaction {
+	return t.txs[0]
+}
+
+// _receiptOf returns the receipt of any mined tx of t, together with the mined tx
+func (c *EthClient) _receiptOf(ctx context.Context, t *trackedTx) (*types.Transaction, *types.Receipt) {
+	for i := len(t.txs) - 1; i >= 0; i-- {
+		rec, err := c.getReceipt(ctx, t.txs[i].Hash())
+		if err != nil || rec == nil {
+			continue
+		}
+		if i > 0 {
+			log.Infof("replacement %x of tx %x mined", t.txs[i].Hash().Bytes(), t.original().Hash().Bytes())
+		}
+		return t.txs[i], rec
+	}
+	return nil, nil
+}
+
+// _replaceIfStuck replaces the tx if its receipt has not been available for a while
+func (c *EthClient) _replaceIfStuck(ctx context.Context, priv []byte, t *trackedTx) {
+	if len(priv) == 0 || t.capped || !c.Replace.enabled() || time.Since(t.sentAt) < c.Replace.Wait {
+		return
+	}
+	if len(t.txs) > c.Replace.MaxBumps {
+		t.capped = true
+		log.Warnf("tx %x replaced %d times, no more replacement", t.original().Hash().Bytes(), c.Replace.MaxBumps)
+		return
+	}
+	latest := t.txs[len(t.txs)-1]
+	journal, _ := getTxJournal(ctx)
+	if journal != nil {
+		// the replacement is for the same thing
+		ctx = putTxJournal(ctx, journal, journal.ref(ctx, latest))
+	}
+	newtx, err := c.bumpTx(ctx, priv, latest)
+	t.sentAt = time.Now()
+	if err != nil {
+		// A tx is returned if it was signed but failed to be sent, try again after another wait.
+		// "nonce too low" means one of the sent txs has been mined, which will be found by the
+		// next poll.
+		log.Warnf("replace tx %x (Nonce:%d) failed: %v", latest.Hash().Bytes(), latest.Nonce(), err)
+		if newtx == nil {
+			t.capped = true
+		} else if journal != nil {
+			// journaled before sending, but not tracked by t
+			journal.remove(ctx, newtx.Hash().Hex())
+		}
+		return
+	}
+	t.txs = append(t.txs, newtx)
+	log.Infof("tx %x (Nonce:%d) stuck, replaced by %x (#%d)", latest.Hash().Bytes(), latest.Nonce(),
+		newtx.Hash().Bytes(), len(t.txs)-1)
+}
+
+// _settled removes t from the journal in ctx, for one of its txs has been mined
+func (c *EthClient) _settled(ctx context.Context, t *trackedTx) {
+	if journal, _ := getTxJournal(ctx); journal != nil {
+		journal.remove(ctx, txHashes(t.txs)...)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestBumpFee(t *testing.T) {
+	tests := []struct {
+		old, suggested, cap int64
+		percent             uint64
+		want                int64
+		ok                  bool
+	}{
+		{old: 100, percent: 20, want: 120, ok: true},
+		{old: 100, percent: 5, want: 110, ok: true}, // at least 10%
+		{old: 1, percent: 20, want: 2, ok: true},    // round up
+		{old: 100, suggested: 150, percent: 20, want: 150, ok: true},
+		{old: 100, percent: 20, cap: 115, want: 115, ok: true},
+		{old: 100, suggested: 150, percent: 20, cap: 130, want: 130, ok: true},
+		{old: 100, percent: 20, cap: 105, ok: false}, // replacement would be rejected
+		{old: 100, percent: 20, cap: 100, ok: false},
+	}
+	for i, test := range tests {
+		var suggested, feeCap *big.Int
+		if test.suggested > 0 {
+			suggested = big.NewInt(test.suggested)
+		}
+		if test.cap > 0 {
+			feeCap = big.NewInt(test.cap)
+		}
+		got, ok := bumpFee(big.NewInt(test.old), suggested, test.percent, feeCap)
+		if ok != test.ok {
+			t.Fatalf("#%d want ok:%t got:%t", i, test.ok, ok)
+		}
+		if ok && got.Int64() != test.want {
+			t.Fatalf("#%d want:%d got:%s", i, test.want, got)
+		}
+	}
+}
+
+func TestReplaceRounds(t *testing.T) {
+	var p *ReplacePolicy
+	if p.rounds(5, defaultRetryInterval) != 5 {
+		t.Fatal("nil policy should not change rounds")
+	}
+	p = &ReplacePolicy{Wait: 12 * time.Second, BumpPercent: 20, MaxBumps: 3}
+	// 5 seconds for each poll, 3 polls for each wait
+	if r := p.rounds(5, 5*time.Second); r != 14 {
+		t.Fatalf("want:14 got:%d", r)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/abi"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ThinkiumGroup/go-tkmrpc/client"
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+var (
+	revertSelector = []byte{0x08, 0xc3, 0x79, 0xa0} // Error(string)
+	panicSelector  = []byte{0x4e, 0x48, 0x7b, 0x71} // Panic(uint256)
+
+	// https://docs.soliditylang.org/en/latest/control-structures.html#panic-via-assert-and-error-via-require
+	panicReasons = map[uint64]string{
+		0x00: "generic compiler inserted panic",
+		0x01: "assert failed",
+		0x11: "arithmetic underflow or overflow",
+		0x12: "division or modulo by zero",
+		0x21: "enum overflow",
+		0x22: "invalid encoded storage byte array",
+		0x31: "pop on empty array",
+		0x32: "array index out of bounds",
+		0x41: "too much memory allocated",
+		0x51: "call to a zero-initialized variable of internal function type",
+	}
+)
+
+// revertABIs returns the contract ABIs whose custom errors could be found in the revert data
+func revertABIs() []*abi.ABI {
+	return []*abi.ABI{&MCSAbi, &LightNodeABI, &XLightNodeAbi, &UpdatableLightNodeAbi}
+}
+
+// decodeRevert decodes the revert data as Error(string), Panic(uint256), or a custom error defined
+// in abis. Returns empty string if it is not recognized.
+func decodeRevert(data []byte, abis ...*abi.ABI) string {
+	if len(data) < 4 {
+		return ""
+	}
+	selector := data[:4]
+	switch {
+	case bytes.Equal(selector, revertSelector):
+		reason, err := abi.UnpackRevert(data)
+		if err != nil {
+			return ""
+		}
+		return reason
+	case bytes.Equal(selector, panicSelector):
+		if len(data) < 36 {
+			return ""
+		}
+		code := new(big.Int).SetBytes(data[4:36])
+		if code.IsUint64() {
+			if reason, exist := panicReasons[code.Uint64()]; exist {
+				return fmt.Sprintf("panic: 0x%x (%s)", code, reason)
+			}
+		}
+		return fmt.Sprintf("panic: 0x%x", code)
+	}
+	for _, a := range abis {
+		if a == nil {
+			continue
+		}
+		for _, e := range a.Errors {
+			if !bytes.Equal(selector, e.ID[:4]) {
+				continue
+			}
+			values, err := e.Unpack(data)
+			if err != nil {
+				return fmt.Sprintf("%s(<undecodable 0x%x>)", e.Name, data[4:])
+			}
+			return formatCustomError(e.Name, values)
+		}
+	}
+	return ""
+}
+
+func formatCustomError(name string, values interface{}) string {
+	args, ok := values.([]interface{})
+	if !ok {
+		return fmt.Sprintf("%s(%v)", name, values)
+	}
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case []byte:
+			strs[i] = fmt.Sprintf("0x%x", v)
+		case [32]byte:
+			strs[i] = fmt.Sprintf("0x%x", v[:])
+		default:
+			strs[i] = fmt.Sprintf("%v", v)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(strs, ", "))
+}
+
+// replayTx executes tx from the sender with eth_call on the state of the block it failed in.
+// The failed tx itself does not change the state of contracts, so it is supposed to fail in the
+// same way, and a RevertError returns.
+func (c *EthClient) replayTx(ctx context.Context, from common.Address, tx *types.Transaction, block *big.Int) error {
+	if err := c._check(); err != nil {
+		return err
+	}
+	msg := ethereum.CallMsg{
+		From:  T2E.Address(from),
+		To:    tx.To(),
+		Gas:   tx.Gas(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+	err := c._do(ctx, reqTimeOut, func(ctx context.Context, cl *ethclient.Client) error {
+		_, err := cl.CallContract(ctx, msg, block)
+		return err
+	})
+	if rerr, ok := asRevertError(err); ok {
+		return rerr
+	}
+	return err
+}
+
+// _failedReason replays the failed tx and returns the decoded reason
+func (a *runner) _failedReason(ctx context.Context, tx *types.Transaction, rcpt *client.ReceiptWithFwds) string {
+	if tx == nil {
+		return "unknown tx"
+	}
+	if rcpt == nil {
+		return "no receipt"
+	}
+	block := new(big.Int).SetUint64(uint64(rcpt.Height))
+	err := a.target.replayTx(ctx, a.targetPriv.Address(), tx, block)
+	var reason string
+	if err == nil {
+		reason = "not reproduced by replay"
+		if e := rcpt.Err(); e != nil {
+			reason = e.Error()
+		}
+	} else if rerr, ok := asRevertError(err); ok {
+		reason = rerr.Error()
+	} else {
+		reason = fmt.Sprintf("replay failed: %v", err)
+	}
+	log.Errorf("tx %x failed at Height:%s: %s", rcpt.TxHash[:], &rcpt.Height, reason)
+	return reason
+}
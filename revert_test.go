@@ -0,0 +1,70 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ThinkiumGroup/go-common/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+func TestDecodeRevert(t *testing.T) {
+	customAbi := abi.MustInitAbi("custom error", `[{"inputs":[{"internalType":"uint256","name":"epoch","type":"uint256"},{"internalType":"bytes32","name":"hash","type":"bytes32"}],"name":"InvalidCommittee","type":"error"}]`)
+	e := customAbi.Errors["InvalidCommittee"]
+	args, err := e.Inputs.Pack(big.NewInt(12), [32]byte{0xab})
+	if err != nil {
+		t.Fatal(err)
+	}
+	custom := append(append([]byte{}, e.ID[:4]...), args...)
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{
+			data: "0x08c379a0" +
+				"0000000000000000000000000000000000000000000000000000000000000020" +
+				"0000000000000000000000000000000000000000000000000000000000000012" +
+				"6f7264657220616c726561647920646f6e650000000000000000000000000000",
+			want: "order already done",
+		},
+		{
+			data: "0x4e487b71" + "0000000000000000000000000000000000000000000000000000000000000011",
+			want: "panic: 0x11 (arithmetic underflow or overflow)",
+		},
+		{
+			data: "0x4e487b71" + "00000000000000000000000000000000000000000000000000000000000000ff",
+			want: "panic: 0xff",
+		},
+		{
+			data: hexutil.Encode(custom),
+			want: "InvalidCommittee(12, 0xab00000000000000000000000000000000000000000000000000000000000000)",
+		},
+		{data: "0x12345678", want: ""},
+		{data: "0x", want: ""},
+	}
+	for i, test := range tests {
+		data := hexutil.MustDecode(test.data)
+		if got := decodeRevert(data, customAbi); got != test.want {
+			t.Fatalf("#%d want:%q got:%q", i, test.want, got)
+		}
+	}
+	// ABIs of the contracts
+	if got := decodeRevert(custom, revertABIs()...); got != "" {
+		t.Fatalf("unexpected custom error: %s", got)
+	}
+}
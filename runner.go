@@ -15,8 +15,6 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"context"
 	"encoding/hex"
 	"errors"
@@ -25,18 +23,15 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	sc "sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/log"
-	"github.com/ThinkiumGroup/go-tkmrpc"
 	"github.com/ThinkiumGroup/go-tkmrpc/client"
 	"github.com/ThinkiumGroup/go-tkmrpc/models"
-	"github.com/bsm/redislock"
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
-	"github.com/stephenfire/go-rtl"
 	"github.com/urfave/cli/v2"
 )
 
@@ -45,7 +40,12 @@ const (
 	// runningLockTTL = 30 * time.Second
 	// sendingLockTTL = 30 * time.Second
 
-	senderLockPrefix = "targetSender"
+	reconnectRetries = 5
+)
+
+var (
+	reconnectMinBackoff = 1 * time.Second
+	reconnectMaxBackoff = 30 * time.Second
 )
 
 type basicHandler interface {
@@ -60,6 +60,7 @@ const (
 	NeedTarget
 	NeedRedis
 	NeedRunningLock
+	NeedConfirm // confirm the config with the chains and reconcile the journal on start
 )
 
 type BitFlags big.Int
@@ -98,18 +99,31 @@ func (f *BitFlags) Clear(bits ...int) *BitFlags {
 type runner struct {
 	conf     *Config
 	needs    *BitFlags
-	src      *client.Client
+	src      *sourcePool
 	target   *EthClient
-	redis    *redis.Client
+	store    StateStore
 	bHandler basicHandler
 
 	keys        redisKeys
-	runningLock *redisLock
-	sendingLock *redisLock
+	runningLock *storeLock
+	sendingLock *storeLock
+	journal     *txJournal
+	http        *httpServer
+	shared      *sharedConns // nil if the connections are owned by the runner
+	runnerType  string
+	reloadCtx   func(ctx context.Context) (*cli.Context, error) // nil if the config could not be reloaded
 
 	// local value
 	targetPriv common.Identifier
 	once       atomic.Bool
+	connLock   sc.RWMutex // guards the changes of src, target and store against other goroutines
+}
+
+// _connections returns the connections for the goroutines other than the one running a
+func (a *runner) _connections() (*sourcePool, *EthClient, StateStore) {
+	a.connLock.RLock()
+	defer a.connLock.RUnlock()
+	return a.src, a.target, a.store
 }
 
 func (a *runner) Name() string {
@@ -123,11 +137,97 @@ func (a *runner) String() string {
 	return fmt.Sprintf("%s{%s}", a.bHandler.Name(), a.keys.runnerLockValue)
 }
 
-func (a *runner) connectionCheck() error {
-	// TODO: try to find out that the link is broken and reconnect
+// connectionCheck finds out whether the links to the source and the target are broken, and
+// rebuilds the broken ones with backoff. The locks held by the runner are refreshed while
+// waiting, so that the runner and its redis state keep going after reconnected.
+func (a *runner) connectionCheck(cctx *cli.Context) error {
+	if a.needs.Bool(NeedSource) {
+		if err := a._sourceAlive(cctx.Context); err != nil {
+			log.Warnf("%s source connection broken: %v", a, err)
+			if err := a._reconnect(cctx, "SOURCE", func() error {
+				return a.src.connect(cctx.Context)
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	if a.needs.Bool(NeedTarget) {
+		if err := a._targetAlive(cctx.Context); err != nil {
+			log.Warnf("%s target connection broken: %v", a, err)
+			if err := a._reconnect(cctx, "TARGET", func() error {
+				cl, err := a._connectTarget(cctx)
+				if err != nil {
+					return err
+				}
+				a.connLock.Lock()
+				old := a.target
+				a.target = cl
+				a.connLock.Unlock()
+				if old != nil {
+					old.Close()
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+func (a *runner) _sourceAlive(ctx context.Context) error {
+	if a.src == nil {
+		return errors.New("not connected")
+	}
+	return a.src.alive(ctx)
+}
+
+func (a *runner) _targetAlive(ctx context.Context) error {
+	if a.target == nil {
+		return errors.New("not connected")
+	}
+	return a.target.alive(ctx)
+}
+
+// _reconnect calls connect until success with an exponential backoff, at most reconnectRetries
+// times. Returns a cli.ExitCoder if the context is done.
+func (a *runner) _reconnect(cctx *cli.Context, name string, connect func() error) error {
+	backoff := reconnectMinBackoff
+	var err error
+	for i := 1; i <= reconnectRetries; i++ {
+		if err = connect(); err == nil {
+			log.Infof("%s %s reconnected", a, name)
+			return nil
+		}
+		log.Warnf("%s reconnect %s failed (%d/%d): %v, retry in %s", a, name, i, reconnectRetries, err, backoff)
+		a._keepLocks(cctx.Context)
+		select {
+		case <-cctx.Done():
+			return cli.Exit(cctx.Err(), ExitByContext)
+		case <-time.After(backoff):
+		}
+		backoff = nextBackoff(backoff)
+	}
+	return fmt.Errorf("reconnect %s failed after %d retries: %w", name, reconnectRetries, err)
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > reconnectMaxBackoff {
+		backoff = reconnectMaxBackoff
+	}
+	return backoff
+}
+
+// _keepLocks refreshes the locks held by the runner, for not losing them during a long wait
+func (a *runner) _keepLocks(ctx context.Context) {
+	for _, l := range []*storeLock{a.runningLock, a.sendingLock} {
+		if l != nil && l.Holding() {
+			_ = l.Refresh(ctx)
+		}
+	}
+}
+
 func (a *runner) getFetchInterval() time.Duration {
 	return time.Duration(a.conf.SrcFetchInterval)
 }
@@ -209,19 +309,31 @@ func (a *runner) prepareConfig(ctx *cli.Context) error {
 	log.Infof("target.sender: 0x%x", sender.Address().Bytes())
 	conf := &Config{
 		RedisAddr:           ctx.String(_redisFlag.Name),
+		StoreFile:           ctx.String(_storeFileFlag.Name),
+		Namespace:           ctx.String(_namespaceFlag.Name),
 		RunningLockTTL:      ctx.Int64(_ttlRunningLcokFlag.Name),
 		SendingLockTTL:      ctx.Int64(_ttlSendingLockFlag.Name),
 		SrcFetchInterval:    ctx.Int64(_intervalFlag.Name),
-		SrcRpcAddr:          ctx.String(_srcRpcFlag.Name),
+		SrcRpcAddrs:         splitAddrs(ctx.String(_srcRpcFlag.Name)),
+		SrcQuorum:           int(ctx.Uint(_srcQuorumFlag.Name)),
+		SrcConcurrency:      int(ctx.Uint(_srcConcurrencyFlag.Name)),
+		SrcPrefilter:        strings.ToLower(ctx.String(_srcPrefilterFlag.Name)),
+		SrcPrefetch:         int(ctx.Uint(_srcPrefetchFlag.Name)),
+		SrcWatchInterval:    int64(ctx.Uint(_srcWatchFlag.Name)),
 		SrcChainId:          common.ChainID(ctx.Uint64(_srcChainFlag.Name)),
 		TargetName:          strings.ToUpper(ctx.String(_targetNameFlag.Name)),
-		TargetApiAddr:       ctx.String(_targetApiFlag.Name),
+		TargetApiAddrs:      splitAddrs(ctx.String(_targetApiFlag.Name)),
 		TargetRetryInterval: ctx.Int64(_retryIntervalFlag.Name),
 		TargetIsTKM:         ctx.Bool(_targetIsTKM.Name),
 		TargetGPTTL:         int64(ctx.Uint64(_targetGPTTL.Name)),
 		TargetCheckBalance:  ctx.Bool(_targetCheckBalance.Name),
 		SrcStartHeight:      ctx.Uint64(_startHeightFlag.Name),
 		SrcIgnoreBlocks:     ctx.Bool(_srcIgnoreBlocks.Name),
+		DryRun:              ctx.Bool(_dryRunFlag.Name),
+	}
+	if conf.DryRun {
+		conf.Namespace = keyBuilder(conf.Namespace).key(dryRunNamespace)
+		log.Warnf("DRY-RUN: txs are simulated and never sent, states are kept in namespace %q", conf.Namespace)
 	}
 	if cid := ctx.Uint64(_targetChainIDFlag.Name); cid > 0 {
 		conf.TargetChainID = new(big.Int).SetUint64(cid)
@@ -229,17 +341,42 @@ func (a *runner) prepareConfig(ctx *cli.Context) error {
 	if conf.TargetName == "" {
 		return cli.Exit(errors.New("target.name required"), ExitByConfig)
 	}
+	txType, err := parseTxType(ctx.String(_targetTxTypeFlag.Name))
+	if err != nil {
+		return cli.Exit(fmt.Errorf("invalid target.txtype: %w", err), ExitByConfig)
+	}
+	conf.TargetFees = &FeePolicy{
+		TxType:    txType,
+		MaxFeeCap: gweiToWei(ctx.Float64(_targetMaxFeeFlag.Name)),
+		MaxTipCap: gweiToWei(ctx.Float64(_targetMaxTipFlag.Name)),
+	}
+	conf.TargetGas = &GasPolicy{
+		Multiplier: ctx.Float64(_targetGasMultiplierFlag.Name),
+		Floor:      ctx.Uint64(_targetGasFloorFlag.Name),
+		Ceiling:    ctx.Uint64(_targetGasCeilingFlag.Name),
+	}
+	if conf.TargetGas.Ceiling > 0 && conf.TargetGas.Floor > conf.TargetGas.Ceiling {
+		return cli.Exit(fmt.Errorf("invalid %s", conf.TargetGas), ExitByConfig)
+	}
+	conf.TargetReplace = &ReplacePolicy{
+		Wait:        time.Duration(ctx.Uint64(_targetReplaceWaitFlag.Name)) * time.Second,
+		BumpPercent: ctx.Uint64(_targetBumpPercentFlag.Name),
+		MaxBumps:    int(ctx.Uint(_targetMaxBumpsFlag.Name)),
+	}
 	a.conf = conf
-	retryInterval = time.Duration(conf.TargetRetryInterval)
-	a.keys.startHeightKey = fmt.Sprintf("%s_start_%d", strings.ToLower(a.Name()), conf.SrcChainId)
-	a.keys.runnerLockKey = fmt.Sprintf("%s_lock_%d", strings.ToLower(a.Name()), conf.SrcChainId)
+	a.keys.startHeightKey = a.keyBuilder().startHeight(a.Name(), conf.SrcChainId)
+	a.keys.runnerLockKey = a.keyBuilder().runnerLock(a.Name(), conf.SrcChainId)
 	a.keys.runnerLockValue = a._runnerLockValue()
-	a.keys.senderLockKey = fmt.Sprintf("%s_%d_0x%x", senderLockPrefix, a.conf.TargetChainID, a.targetPriv.Address().Bytes())
-	a.needs = a.needs.Set(NeedSource, NeedTarget, NeedRedis, NeedRunningLock)
+	a.keys.senderLockKey = a.keyBuilder().senderLock(a.conf.TargetChainID, a.targetPriv.Address())
+	a.needs = a.needs.Set(NeedSource, NeedTarget, NeedRedis, NeedRunningLock, NeedConfirm)
 
 	return nil
 }
 
+func (a *runner) keyBuilder() keyBuilder {
+	return keyBuilder(a.conf.Namespace)
+}
+
 func (a *runner) _ipAndPid() (string, int) {
 	ip, err := localip()
 	if err != nil {
@@ -249,69 +386,48 @@ func (a *runner) _ipAndPid() (string, int) {
 }
 func (a *runner) _runnerLockValue() string {
 	ip, pid := a._ipAndPid()
-	return fmt.Sprintf("%s@%d", ip, pid)
+	if a.shared == nil {
+		return fmt.Sprintf("%s@%d", ip, pid)
+	}
+	// the runners in one process must not hold the same lock at the same time, they are different
+	// in name or source chain
+	name := a.Name()
+	if a.bHandler != nil {
+		name = a.bHandler.Name()
+	}
+	return fmt.Sprintf("%s@%d#%s@%d", ip, pid, name, a.conf.SrcChainId)
 }
 
 func (a *runner) confirmConfig(cctx *cli.Context) error {
 	if a.needs.Bool(NeedRedis) {
 		ctx, cancel := context.WithTimeout(cctx.Context, redisTimeout*2)
 		defer cancel()
-		status := a.redis.Ping(ctx)
-		if status.Val() != "PONG" {
-			return errors.New("ping redis server failed")
+		if err := a.store.Ping(ctx); err != nil {
+			return fmt.Errorf("ping %s failed: %w", a.store, err)
 		}
-		info := a.redis.Info(ctx, "server")
-		r := bufio.NewReader(bytes.NewBufferString(info.Val()))
-		version := ""
-		for i := 0; i < 20; i++ {
-			line, _, err := r.ReadLine()
-			if len(line) > 0 {
-				if strings.Contains(string(line), "redis_version") {
-					parts := strings.Split(string(line), ":")
-					if len(parts) > 1 {
-						version = strings.TrimSpace(parts[1])
-					}
-					break
-				}
-			}
-			if err != nil {
-				break
-			}
-		}
-		log.Infof("redis server version: %s", version)
+		log.Infof("state store: %s", a.store.Describe(ctx))
 	}
 
 	return nil
 }
 
-func (a *runner) _connectSource(ctx *cli.Context) (c *client.Client, errr error) {
+func (a *runner) _connectSource(ctx *cli.Context) (*sourcePool, error) {
 	if !a.needs.Bool(NeedSource) {
 		return nil, nil
 	}
-	conn := &client.Client{
-		Server:       a.conf.SrcRpcAddr,
-		CurrentChain: a.conf.SrcChainId,
-	}
-	if err := conn.NewClient(); err != nil {
-		return nil, fmt.Errorf("connect TKM @%s failed: %w", a.conf.SrcRpcAddr, err)
+	if a.shared != nil {
+		return a.shared.source(ctx.Context, a.conf)
 	}
-
-	defer func() {
-		if errr != nil {
-			c = nil
-			_ = conn.Close()
-		}
-	}()
-
-	stats, err := conn.ChainStats(ctx.Context)
+	pool, err := newSourcePool(a.conf.SrcRpcAddrs, a.conf.SrcChainId, a.conf.SrcQuorum)
 	if err != nil {
-		return nil, fmt.Errorf("tkm stats failed: %w", err)
+		return nil, cli.Exit(err, ExitByConfig)
 	}
-	if stats == nil || stats.ChainID != a.conf.SrcChainId {
-		return nil, fmt.Errorf("TKM@%s ChainID:%d required, but %s", a.conf.SrcRpcAddr, a.conf.SrcChainId, stats.String())
+	if err := pool.connect(ctx.Context); err != nil {
+		_ = pool.Close()
+		return nil, fmt.Errorf("connect TKM@%s failed: %w", a.conf.SrcRpcAddrs, err)
 	}
-	log.Infof("TKM@%s connected: %s", a.conf.SrcRpcAddr, stats)
-	return conn, nil
+	log.Infof("%s connected", pool)
+	return pool, nil
 }
 
 func (a *runner) _connectTarget(ctx *cli.Context) (c *EthClient, errr error) {
@@ -322,33 +438,33 @@ func (a *runner) _connectTarget(ctx *cli.Context) (c *EthClient, errr error) {
 	if a.conf.TargetChainID != nil {
 		chainid = new(big.Int).Set(a.conf.TargetChainID)
 	}
-	cl, err := NewEthClient(ctx.Context, a.conf.TargetApiAddr, chainid, a.conf.TargetGPTTL)
+	cl, err := NewEthClient(ctx.Context, a.conf.TargetApiAddrs, chainid, a.conf.TargetGPTTL)
 	if err != nil || cl == nil {
-		return nil, fmt.Errorf("connect TARGET@%s failed: %w", a.conf.TargetApiAddr, err)
+		return nil, fmt.Errorf("connect TARGET@%s failed: %w", a.conf.TargetApiAddrs, err)
+	}
+	if err = cl.SetFeePolicy(ctx.Context, a.conf.TargetFees); err != nil {
+		cl.Close()
+		return nil, fmt.Errorf("TARGET@%s fee policy failed: %w", a.conf.TargetApiAddrs, err)
 	}
+	cl.Replace = a.conf.TargetReplace
+	cl.RetryInterval = time.Duration(a.conf.TargetRetryInterval) * time.Second
+	log.Infof("%s with %s %s", cl, cl.Fees, cl.Replace)
 
 	if a.conf.TargetChainID == nil {
 		a.conf.TargetChainID = new(big.Int).Set(cl.ChainId)
-		a.keys.senderLockKey = fmt.Sprintf("%s_%d_0x%x", senderLockPrefix, a.conf.TargetChainID, a.targetPriv.Address().Bytes())
+		a.keys.senderLockKey = a.keyBuilder().senderLock(a.conf.TargetChainID, a.targetPriv.Address())
 		log.Infof("SENDER_LOCK_KEY_UPDATED: %s", a.keys)
 	}
 	return cl, nil
 }
 
-func (a *runner) _targetSuggestBalance(ctx context.Context) (gas uint64, mustHave *big.Int) {
-	gas = defaultGas
-	gasprice, err := a.target.suggestGasPrice(ctx)
-	if err != nil || gasprice == nil {
-		return gas, nil
-	}
-	mustHave = new(big.Int).Mul(new(big.Int).SetUint64(gas), gasprice)
-	return gas, mustHave
-}
-
 func (a *runner) start(ctx *cli.Context) (errr error) {
 	if a.once.CompareAndSwap(false, true) {
-		ip, pid := a._ipAndPid()
-		log.SetFields(logrus.Fields{ip: pid})
+		if a.shared == nil {
+			// the supervisor of shared runners sets the fields once for the process
+			ip, pid := a._ipAndPid()
+			log.SetFields(logrus.Fields{ip: pid})
+		}
 		defer func() {
 			if errr != nil {
 				_ = a.close(ctx)
@@ -358,32 +474,49 @@ func (a *runner) start(ctx *cli.Context) (errr error) {
 		if err != nil {
 			return err
 		}
+		a.connLock.Lock()
 		a.src = conn
+		a.connLock.Unlock()
 
 		cl, err := a._connectTarget(ctx)
 		if err != nil {
 			return err
 		}
+		a.connLock.Lock()
 		a.target = cl
+		a.connLock.Unlock()
 
 		if a.needs.Bool(NeedRedis) {
-			opts, err := redis.ParseURL(a.conf.RedisAddr)
+			var store StateStore
+			if a.shared != nil {
+				store, err = a.shared.store(a.conf)
+			} else {
+				store, err = newStateStore(a.conf)
+			}
 			if err != nil {
-				return cli.Exit(fmt.Errorf("redis URL parse failed: %w", err), ExitByConfig)
+				return cli.Exit(err, ExitByConfig)
+			}
+			log.Debugf("connecting %s", store)
+			a.connLock.Lock()
+			a.store = store
+			a.connLock.Unlock()
+			a.runningLock = newStoreLock(a.store, a.keys.runnerLockKey, a.keys.runnerLockValue, time.Duration(a.conf.RunningLockTTL)*time.Second)
+			a.sendingLock = newStoreLock(a.store, a.keys.senderLockKey, a.keys.runnerLockValue, time.Duration(a.conf.SendingLockTTL)*time.Second)
+			a.runningLock.labels = a._metricLabels(lockRunning)
+			a.sendingLock.labels = a._metricLabels(lockSending)
+			if a.needs.Bool(NeedTarget) {
+				a.journal = newTxJournal(a.store, a.keys.senderLockKey, a.String())
 			}
-			log.Debugf("connecting redis at ADDRESS: %s, DB: %d", opts.Addr, opts.DB)
-			rds := redis.NewClient(opts)
-			a.redis = rds
-			locker := redislock.New(a.redis)
-			a.runningLock = newRedisLock(a.redis, locker, a.keys.runnerLockKey, a.keys.runnerLockValue, time.Duration(a.conf.RunningLockTTL)*time.Second)
-			a.sendingLock = newRedisLock(a.redis, locker, a.keys.senderLockKey, a.keys.runnerLockValue, time.Duration(a.conf.SendingLockTTL)*time.Second)
 		} else {
-			a.redis = nil
+			a.connLock.Lock()
+			a.store = nil
+			a.connLock.Unlock()
 			a.runningLock = nil
 			a.sendingLock = nil
+			a.journal = nil
 		}
 
-		if a.bHandler != nil {
+		if a.bHandler != nil && a.needs.Bool(NeedConfirm) {
 			if err := a.bHandler.confirmConfig(ctx); err != nil {
 				return err
 			}
@@ -393,6 +526,12 @@ func (a *runner) start(ctx *cli.Context) (errr error) {
 			}
 		}
 
+		if a.needs.Bool(NeedConfirm) {
+			if err := a.reconcileJournal(ctx); err != nil {
+				return err
+			}
+		}
+
 		log.Infof("%s STARTED", a.String())
 		return nil
 	} else {
@@ -402,13 +541,15 @@ func (a *runner) start(ctx *cli.Context) (errr error) {
 
 func (a *runner) close(_ *cli.Context) error {
 	if a.once.CompareAndSwap(true, false) {
-		if a.src != nil {
-			_ = a.src.Close()
-			a.src = nil
+		a.connLock.Lock()
+		src, target, store := a.src, a.target, a.store
+		a.src, a.target, a.store = nil, nil, nil
+		a.connLock.Unlock()
+		if src != nil && a.shared == nil {
+			_ = src.Close()
 		}
-		if a.target != nil {
-			a.target.Close()
-			a.target = nil
+		if target != nil {
+			target.Close()
 		}
 		if a.sendingLock != nil {
 			// use an available context for making sure to release
@@ -417,9 +558,14 @@ func (a *runner) close(_ *cli.Context) error {
 		if a.runningLock != nil {
 			_ = a.runningLock.Release()
 		}
-		if a.redis != nil {
-			_ = a.redis.Close()
-			a.redis = nil
+		if store != nil && a.shared == nil {
+			_ = store.Close()
+		}
+		a.journal = nil
+		if a.http != nil {
+			runnerHealth.unregister(a.String())
+			a.http.stop()
+			a.http = nil
 		}
 		log.Warnf("%s CLOSED", a.String())
 		return nil
@@ -428,7 +574,8 @@ func (a *runner) close(_ *cli.Context) error {
 	}
 }
 
-func (a *runner) run(cctx *cli.Context) error {
+// initGlobals sets the log file and the chain parameters of go-common from the flags
+func initGlobals(cctx *cli.Context) {
 	if logpath := cctx.String(_logFileFlag.Name); len(logpath) > 0 {
 		pid := strconv.Itoa(os.Getpid())
 		log.InitLogWithSuffix(logpath, pid)
@@ -446,7 +593,10 @@ func (a *runner) run(cctx *cli.Context) error {
 		log.SetFields(logrus.Fields{"Base": common.BigChainIDBase})
 	}
 	log.Infof("common.BlocksInEpoch: %d, common.BigChainIDBase: %d", common.BlocksInEpoch, common.BigChainIDBase)
+}
 
+func (a *runner) run(cctx *cli.Context) error {
+	initGlobals(cctx)
 	if a.bHandler != nil {
 		if err := a.bHandler.prepareConfig(cctx); err != nil {
 			return err
@@ -457,6 +607,9 @@ func (a *runner) run(cctx *cli.Context) error {
 		}
 	}
 	log.SetFields(logrus.Fields{a.bHandler.Name(): cctx.Uint(_srcChainFlag.Name)})
+	a.reloadCtx = func(ctx context.Context) (*cli.Context, error) {
+		return reloadContext(cctx, ctx)
+	}
 	defer func() {
 		_ = a.close(cctx)
 	}()
@@ -464,6 +617,14 @@ func (a *runner) run(cctx *cli.Context) error {
 	if err := a.start(cctx); err != nil {
 		return err
 	}
+	if addr := cctx.String(_httpFlag.Name); len(addr) > 0 {
+		a.http = newHTTPServer(addr)
+		if err := a.http.start(); err != nil {
+			a.http = nil
+			return cli.Exit(err, ExitByConfig)
+		}
+		runnerHealth.register(a.String(), a)
+	}
 	if a.bHandler != nil {
 		if err := a.bHandler.doWork(cctx); err != nil {
 			return err
@@ -480,8 +641,24 @@ func (a *runner) _srcStats(cctx *cli.Context) (*models.ChainStats, error) {
 	return a.src.ChainStats(ctx)
 }
 
+// _simulateTx calls the contract with the input instead of signing and sending the tx in the
+// dry-run mode, a revert returns an error with the decoded reason.
+func (a *runner) _simulateTx(ctx context.Context, to *common.Address, input []byte, ref string) error {
+	output, err := a.target.callContract(ctx, a.targetPriv.Address(), to, defaultGas, nil, nil, input)
+	if err != nil {
+		if rerr, ok := asRevertError(err); ok {
+			return fmt.Errorf("DRY-RUN %s: %w", ref, rerr)
+		}
+		return fmt.Errorf("DRY-RUN %s call failed: %w", ref, err)
+	}
+	log.Warnf("DRY-RUN %s to %x simulated, input:%d bytes, output:%x", ref, to[:], len(input), output)
+	return nil
+}
+
 type looperHandler interface {
 	prepareToGet(cctx *cli.Context, start common.Height) error
+	// prefetchable returns whether the batch at start could be fetched ahead, without side effects
+	prefetchable(cctx *cli.Context, start common.Height) bool
 	processBlocks(cctx *cli.Context, blocks *client.RpcBlocks) (next common.Height, errr error)
 	prepareBlocks(cctx *cli.Context, blocks *client.RpcBlocks) (goon bool, err error)
 	processBlock(cctx *cli.Context, block *models.BlockEMessage) (fatal, warning error)
@@ -500,32 +677,36 @@ func (a *looper) doWork(ctx *cli.Context) error {
 	interval := time.Second * a.getFetchInterval()
 	timer := time.NewTimer(interval)
 	defer timer.Stop()
+	reloads, unsubscribe := configReloads.subscribe()
+	defer unsubscribe()
+	var heads <-chan struct{}
+	if a.conf.SrcWatchInterval > 0 {
+		wctx, cancel := context.WithCancel(ctx.Context)
+		defer cancel()
+		heads = newHeadWatcher(a.src, time.Millisecond*time.Duration(a.conf.SrcWatchInterval)).watch(wctx)
+	}
 	for {
 		select {
-		case <-timer.C:
-			if err := a.connectionCheck(); err != nil {
-				return err
+		case <-reloads:
+			if err := a.reload(ctx); err != nil {
+				log.Errorf("%s reload failed: %v", a, err)
 			}
-			value, err := a.runningLock.FetchOrRefresh(ctx.Context)
-			if err != nil {
-				log.Debugf("[%s] is running, fetch-refresh %s failed: %v", value, a.runningLock, err)
-			} else {
-				if err := a.iterateBlocks(ctx); err != nil {
-					var exitErr cli.ExitCoder
-					if errors.As(err, &exitErr) {
-						return err
-					} else {
-						var unlockErr LockError
-						if errors.As(err, &unlockErr) && !unlockErr.Unlock() {
-							log.Warnf("iterate failed [NR]: %v", err)
-						} else {
-							log.Errorf("iterate failed [RELEASED]: %v", err)
-							_ = a.runningLock.Release()
-							_ = a.sendingLock.Release()
-						}
-					}
+			interval = time.Second * a.getFetchInterval()
+		case <-heads:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
 				}
 			}
+			if err := a._round(ctx); err != nil {
+				return err
+			}
+			timer.Reset(interval)
+		case <-timer.C:
+			if err := a._round(ctx); err != nil {
+				return err
+			}
 			timer.Reset(interval)
 		case <-ctx.Done():
 			return cli.Exit(ctx.Err(), ExitByContext)
@@ -533,22 +714,65 @@ func (a *looper) doWork(ctx *cli.Context) error {
 	}
 }
 
+// _round checks the connections and iterates the blocks under the running lock, only the
+// errors stopping the looper are returned.
+func (a *looper) _round(ctx *cli.Context) error {
+	if err := a.connectionCheck(ctx); err != nil {
+		var exitErr cli.ExitCoder
+		if errors.As(err, &exitErr) {
+			return err
+		}
+		log.Errorf("connection check failed [RELEASED]: %v", err)
+		_ = a.runningLock.Release()
+		_ = a.sendingLock.Release()
+		return nil
+	}
+	a._observeBalance(ctx.Context)
+	value, err := a.runningLock.FetchOrRefresh(ctx.Context)
+	if err != nil {
+		log.Debugf("[%s] is running, fetch-refresh %s failed: %v", value, a.runningLock, err)
+		return nil
+	}
+	if err := a._underLease(ctx, a.iterateBlocks, a.runningLock); err != nil {
+		var exitErr cli.ExitCoder
+		if errors.As(err, &exitErr) {
+			return err
+		}
+		var unlockErr LockError
+		if errors.As(err, &unlockErr) && !unlockErr.Unlock() {
+			log.Warnf("iterate failed [NR]: %v", err)
+		} else {
+			log.Errorf("iterate failed [RELEASED]: %v", err)
+			_ = a.runningLock.Release()
+			_ = a.sendingLock.Release()
+		}
+	}
+	return nil
+}
+
 func (a *looper) getStartHeight(cctx *cli.Context) common.Height {
 	ctx, cancel := context.WithTimeout(cctx.Context, redisTimeout)
 	defer cancel()
-	h, err := a.redis.Get(ctx, a.keys.startHeightKey).Uint64()
+	h, exist, err := a.store.Cursor(ctx, a.keys.startHeightKey)
+	var height common.Height
 	switch {
-	case err != nil:
-		return common.Height(a.conf.SrcStartHeight)
+	case err != nil || !exist:
+		height = common.Height(a.conf.SrcStartHeight)
 	default:
-		return common.Height(h)
+		height = common.Height(h)
 	}
+	a._observeHeight(cursorGauge, height)
+	return height
 }
 
 func (a *looper) updateStartHeight(cctx *cli.Context, newHeight common.Height) error {
 	ctx, cancel := context.WithTimeout(cctx.Context, redisTimeout)
 	defer cancel()
-	return a.redis.Set(ctx, a.keys.startHeightKey, fmt.Sprintf("%d", newHeight), 0).Err()
+	if err := a.store.SetCursor(ctx, a.keys.startHeightKey, uint64(newHeight)); err != nil {
+		return err
+	}
+	a._observeHeight(cursorGauge, newHeight)
+	return nil
 }
 
 func (a *looper) prepareToGet(_ *cli.Context, _ common.Height) error {
@@ -559,28 +783,22 @@ func (a *looper) _tkmBlocks(cctx *cli.Context, start common.Height) (*client.Rpc
 	if err := a.lHander.prepareToGet(cctx, start); err != nil {
 		return nil, err
 	}
-	ctx, cancel := context.WithTimeout(cctx.Context, reqTimeOut)
-	defer cancel()
-	resp, err := a.src.NodeClient.GetBlocks(ctx, &tkmrpc.RpcBlockHeight{
-		Chainid: uint32(a.conf.SrcChainId),
-		Height:  uint64(start),
-	})
+	blocks, err := a.src.Blocks(cctx.Context, start)
 	if err != nil {
-		return nil, fmt.Errorf("get blocks starting at %d failed: %w", start, err)
-	}
-	if !resp.Success() {
-		log.Warnf("get blocks starting at %d failed, wait another fetch", start)
-		return nil, nil
-	}
-	blocks := new(client.RpcBlocks)
-	if err = rtl.Unmarshal(resp.Stream, blocks); err != nil {
-		return nil, fmt.Errorf("unmarshal blocks failed: %w", err)
+		return nil, err
 	}
+	return a._gotBlocks(start, blocks), nil
+}
+
+func (a *looper) _gotBlocks(start common.Height, blocks *client.RpcBlocks) *client.RpcBlocks {
 	log.Infof("get %s starting at %d", blocks, start)
+	if blocks != nil {
+		a._observeHeight(sourceHeadGauge, blocks.Current)
+	}
 	if blocks == nil || len(blocks.Blocks) == 0 {
-		return nil, nil
+		return nil
 	}
-	return blocks, nil
+	return blocks
 }
 
 func (a *looper) iterateBlocks(cctx *cli.Context) error {
@@ -591,13 +809,23 @@ func (a *looper) iterateBlocks(cctx *cli.Context) error {
 	if start.IsNil() {
 		start = 0
 	}
+	var pf *prefetcher
+	if a.conf.SrcPrefetch > 0 {
+		pf = newPrefetcher(a, a.conf.SrcPrefetch)
+		defer pf.stop()
+	}
 	for {
 		select {
 		case <-cctx.Done():
 			return cli.Exit(cctx.Err(), ExitByContext)
 		default:
-			_ = a.runningLock.Refresh(cctx.Context)
-			blocks, err := a._tkmBlocks(cctx, start)
+			var blocks *client.RpcBlocks
+			var err error
+			if pf != nil {
+				blocks, err = pf.blocks(cctx, start)
+			} else {
+				blocks, err = a._tkmBlocks(cctx, start)
+			}
 			if err != nil {
 				return err
 			}
@@ -619,7 +847,9 @@ func (a *looper) iterateBlocks(cctx *cli.Context) error {
 			if start.Compare(blocks.Current) > 0 {
 				return nil
 			}
-			time.Sleep(time.Second)
+			if pf == nil {
+				time.Sleep(time.Second)
+			}
 		}
 	}
 }
@@ -0,0 +1,78 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestNextBackoff(t *testing.T) {
+	backoff := reconnectMinBackoff
+	for _, want := range []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second,
+		30 * time.Second, 30 * time.Second} {
+		if backoff = nextBackoff(backoff); backoff != want {
+			t.Fatalf("backoff want:%s got:%s", want, backoff)
+		}
+	}
+}
+
+func TestRunnerReconnect(t *testing.T) {
+	minBackoff, maxBackoff := reconnectMinBackoff, reconnectMaxBackoff
+	reconnectMinBackoff, reconnectMaxBackoff = time.Millisecond, 4*time.Millisecond
+	defer func() {
+		reconnectMinBackoff, reconnectMaxBackoff = minBackoff, maxBackoff
+	}()
+	a := &runner{conf: &Config{TargetName: "RECONNECT"}}
+	cctx := &cli.Context{Context: context.Background()}
+	errBroken := errors.New("broken")
+
+	tries := 0
+	if err := a._reconnect(cctx, "TEST", func() error {
+		tries++
+		if tries < 3 {
+			return errBroken
+		}
+		return nil
+	}); err != nil || tries != 3 {
+		t.Fatalf("reconnected at the 3rd try expected, got:%d %v", tries, err)
+	}
+
+	tries = 0
+	err := a._reconnect(cctx, "TEST", func() error {
+		tries++
+		return errBroken
+	})
+	if !errors.Is(err, errBroken) || tries != reconnectRetries {
+		t.Fatalf("%d retries expected, got:%d %v", reconnectRetries, tries, err)
+	}
+	t.Log(err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tries = 0
+	err = a._reconnect(&cli.Context{Context: ctx}, "TEST", func() error {
+		tries++
+		cancel()
+		return errBroken
+	})
+	var exitErr cli.ExitCoder
+	if !errors.As(err, &exitErr) || exitErr.ExitCode() != ExitByContext || tries != 1 {
+		t.Fatalf("exit by context expected, got:%d %v", tries, err)
+	}
+}
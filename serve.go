@@ -0,0 +1,334 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	sc "sync"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	routeTypeKey = "type"
+
+	routeMinBackoff = 1 * time.Second
+	routeMaxBackoff = 5 * time.Minute
+)
+
+// _processFlags are shared by the whole process, and could not be set by a route
+var _processFlags = []cli.Flag{_confFileFlag, _logFileFlag, _httpFlag, _srcBlocksInEpochFlag,
+	_srcBaseChainIDFlag, _serveRoutesFlag}
+
+// route is a runner in the serve command, its flags override the ones of the command
+type route struct {
+	runnerType string
+	flags      map[string]string
+}
+
+func (r *route) String() string {
+	name := r.flags[_targetNameFlag.Name]
+	return fmt.Sprintf("Route{%s %s}", r.runnerType, name)
+}
+
+// context returns a context of the command with the flags of the route
+func (r *route) context(cctx *cli.Context, ctx context.Context) (*cli.Context, error) {
+	return overlayContext(cctx, ctx, r.String(), r.flags)
+}
+
+func _flagNames(flagss ...[]cli.Flag) map[string]bool {
+	names := make(map[string]bool)
+	for _, flags := range flagss {
+		for _, f := range flags {
+			for _, name := range f.Names() {
+				names[name] = true
+			}
+		}
+	}
+	return names
+}
+
+func _scalarOf(node *yaml.Node) (string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Value, nil
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, n := range node.Content {
+			if n.Kind != yaml.ScalarNode {
+				return "", fmt.Errorf("line %d: scalar expected", n.Line)
+			}
+			values = append(values, n.Value)
+		}
+		return strings.Join(values, ","), nil
+	default:
+		return "", fmt.Errorf("line %d: scalar or sequence expected", node.Line)
+	}
+}
+
+// parseRoutes parses a YAML list of routes. Each route is a map with the runner type at "type",
+// and the flags of the route at other keys. The values are kept as they are in the YAML text, and
+// sequences are joined by comma.
+func parseRoutes(data []byte) ([]*route, error) {
+	var nodes []map[string]yaml.Node
+	if err := yaml.Unmarshal(data, &nodes); err != nil {
+		return nil, fmt.Errorf("parse routes failed: %w", err)
+	}
+	processFlags := _flagNames(_processFlags)
+	seen := make(map[string]bool)
+	var routes []*route
+	for i, node := range nodes {
+		typeNode, exist := node[routeTypeKey]
+		if !exist {
+			return nil, fmt.Errorf("route %d: %s is missing", i, routeTypeKey)
+		}
+		r := &route{runnerType: strings.ToLower(typeNode.Value), flags: make(map[string]string)}
+		var known map[string]bool
+		for _, t := range _runnerTypes {
+			if t.name == r.runnerType {
+				known = _flagNames(_allFlags, t.flags)
+			}
+		}
+		if known == nil {
+			return nil, fmt.Errorf("route %d: unknown runner type %q", i, typeNode.Value)
+		}
+		for name, value := range node {
+			if name == routeTypeKey {
+				continue
+			}
+			if !known[name] {
+				return nil, fmt.Errorf("route %d: unknown flag %s for %s", i, name, r.runnerType)
+			}
+			if processFlags[name] {
+				return nil, fmt.Errorf("route %d: %s could not be set by a route", i, name)
+			}
+			v, err := _scalarOf(&value)
+			if err != nil {
+				return nil, fmt.Errorf("route %d: %s %w", i, name, err)
+			}
+			r.flags[name] = v
+		}
+		// names of the runners are in upper case
+		id := strings.ToUpper(r.String()) + r.flags[_srcChainFlag.Name]
+		if seen[id] {
+			return nil, fmt.Errorf("route %d: %s duplicated", i, r)
+		}
+		seen[id] = true
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
+
+// sharedConns are the connections shared by the runners of one process, keyed by their configs.
+// They are closed by the supervisor instead of the runners.
+type sharedConns struct {
+	lock    sc.Mutex
+	sources map[string]*sourcePool
+	stores  map[string]StateStore
+}
+
+func newSharedConns() *sharedConns {
+	return &sharedConns{
+		sources: make(map[string]*sourcePool),
+		stores:  make(map[string]StateStore),
+	}
+}
+
+func (s *sharedConns) source(ctx context.Context, conf *Config) (*sourcePool, error) {
+	key := fmt.Sprintf("%d/%d/%s", conf.SrcChainId, conf.SrcQuorum, strings.Join(conf.SrcRpcAddrs, ","))
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if pool, exist := s.sources[key]; exist {
+		return pool, nil
+	}
+	pool, err := newSourcePool(conf.SrcRpcAddrs, conf.SrcChainId, conf.SrcQuorum)
+	if err != nil {
+		return nil, cli.Exit(err, ExitByConfig)
+	}
+	if err := pool.connect(ctx); err != nil {
+		_ = pool.Close()
+		return nil, fmt.Errorf("connect TKM@%s failed: %w", conf.SrcRpcAddrs, err)
+	}
+	log.Infof("%s connected and shared", pool)
+	s.sources[key] = pool
+	return pool, nil
+}
+
+func (s *sharedConns) store(conf *Config) (StateStore, error) {
+	key := "redis:" + conf.RedisAddr
+	if len(conf.StoreFile) > 0 {
+		key = "file:" + conf.StoreFile
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if store, exist := s.stores[key]; exist {
+		return store, nil
+	}
+	store, err := newStateStore(conf)
+	if err != nil {
+		return nil, err
+	}
+	s.stores[key] = store
+	return store, nil
+}
+
+func (s *sharedConns) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for key, pool := range s.sources {
+		_ = pool.Close()
+		delete(s.sources, key)
+	}
+	for key, store := range s.stores {
+		_ = store.Close()
+		delete(s.stores, key)
+	}
+}
+
+type supervisor struct {
+	shared *sharedConns
+}
+
+// _runRoute runs the runner of the route until it stops, a panic is returned as an error
+func (s *supervisor) _runRoute(cctx *cli.Context, r *route) (errr error) {
+	defer func() {
+		if p := recover(); p != nil {
+			errr = fmt.Errorf("%s panic: %v", r, p)
+		}
+	}()
+	rctx, err := r.context(cctx, cctx.Context)
+	if err != nil {
+		return cli.Exit(err, ExitByConfig)
+	}
+	h, err := newRunnerOfType(r.runnerType)
+	if err != nil {
+		return cli.Exit(err, ExitByConfig)
+	}
+	a := h.base()
+	a.shared = s.shared
+	a.reloadCtx = func(ctx context.Context) (*cli.Context, error) {
+		yctx, err := reloadContext(cctx, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return r.context(yctx, ctx)
+	}
+	if err := h.prepareConfig(rctx); err != nil {
+		return err
+	}
+	defer func() {
+		_ = a.close(rctx)
+	}()
+	if err := a.start(rctx); err != nil {
+		return err
+	}
+	runnerHealth.register(a.String(), a)
+	defer runnerHealth.unregister(a.String())
+	return h.doWork(rctx)
+}
+
+// _supervise restarts the route with an exponential backoff until the context is done, or the
+// route stops by itself or by its config
+func (s *supervisor) _supervise(cctx *cli.Context, r *route) {
+	backoff := routeMinBackoff
+	for {
+		started := time.Now()
+		err := s._runRoute(cctx, r)
+		if cctx.Err() != nil {
+			return
+		}
+		var exitErr cli.ExitCoder
+		if err == nil || (errors.As(err, &exitErr) && exitErr.ExitCode() == 0) {
+			log.Infof("%s finished: %v", r, err)
+			return
+		}
+		if errors.As(err, &exitErr) && (exitErr.ExitCode() == ExitByConfig || exitErr.ExitCode() == ExitByInput) {
+			log.Errorf("%s stopped: %v", r, err)
+			return
+		}
+		if time.Since(started) > routeMaxBackoff {
+			backoff = routeMinBackoff
+		}
+		log.Errorf("%s failed: %v, restart in %s", r, err, backoff)
+		select {
+		case <-cctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > routeMaxBackoff {
+			backoff = routeMaxBackoff
+		}
+	}
+}
+
+func serve(cctx *cli.Context) error {
+	path := cctx.String(_serveRoutesFlag.Name)
+	if len(path) == 0 {
+		return checkerror(cli.Exit(fmt.Errorf("%s required", _serveRoutesFlag.Name), ExitByConfig))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return checkerror(cli.Exit(fmt.Errorf("read routes failed: %w", err), ExitByConfig))
+	}
+	routes, err := parseRoutes(data)
+	if err != nil {
+		return checkerror(cli.Exit(err, ExitByConfig))
+	}
+	if len(routes) == 0 {
+		return checkerror(cli.Exit(errors.New("no route found"), ExitByConfig))
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].String() < routes[j].String() })
+
+	initGlobals(cctx)
+	ip, err := localip()
+	if err != nil {
+		return checkerror(cli.Exit(err, ExitUnknown))
+	}
+	log.SetFields(logrus.Fields{ip: os.Getpid()})
+	if addr := cctx.String(_httpFlag.Name); len(addr) > 0 {
+		server := newHTTPServer(addr)
+		if err := server.start(); err != nil {
+			return checkerror(cli.Exit(err, ExitByConfig))
+		}
+		defer server.stop()
+	}
+
+	s := &supervisor{shared: newSharedConns()}
+	defer s.shared.Close()
+	var wg sc.WaitGroup
+	for _, r := range routes {
+		wg.Add(1)
+		go func(r *route) {
+			defer wg.Done()
+			s._supervise(cctx, r)
+		}(r)
+	}
+	log.Infof("serving %d routes", len(routes))
+	wg.Wait()
+	if err := cctx.Err(); err != nil {
+		return checkerror(cli.Exit(err, ExitByContext))
+	}
+	return checkerror(cli.Exit(errors.New("all routes stopped"), ExitBasicHandlerErr))
+}
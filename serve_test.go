@@ -0,0 +1,149 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/urfave/cli/v2"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := parseRoutes([]byte(`
+- type: sync
+  target.name: BSC
+  target.api: [https://a.example, https://b.example]
+  target.chainid: 97
+  sync.targetmcs: 0x01
+- type: maintain
+  target.name: BSC
+  maintain.targetlc: 0x0000000000000000000000000000000000000002
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 2 {
+		t.Fatalf("2 routes expected: %v", routes)
+	}
+	r := routes[0]
+	if r.runnerType != "sync" || r.flags["sync.targetmcs"] != "0x01" ||
+		r.flags["target.api"] != "https://a.example,https://b.example" || r.flags["target.chainid"] != "97" {
+		t.Fatalf("invalid route: %s %v", r, r.flags)
+	}
+
+	for _, invalid := range []string{
+		"- target.name: BSC",
+		"- {type: relay, target.name: BSC}",
+		"- {type: sync, maintain.targetlc: 0x01}",
+		"- {type: sync, src.blocksinepoch: 100}",
+		"- {type: sync, target.name: BSC}\n- {type: sync, target.name: BSC}",
+	} {
+		if _, err := parseRoutes([]byte(invalid)); err == nil {
+			t.Fatalf("%q should be invalid", invalid)
+		} else {
+			t.Log(err)
+		}
+	}
+}
+
+func TestRouteContext(t *testing.T) {
+	set := flag.NewFlagSet("serve", flag.ContinueOnError)
+	set.String(_targetNameFlag.Name, "DEFAULT", "")
+	set.Uint64(_srcChainFlag.Name, 50001, "")
+	parent := cli.NewContext(cli.NewApp(), set, nil)
+
+	r := &route{runnerType: "sync", flags: map[string]string{_targetNameFlag.Name: "BSC"}}
+	rctx, err := r.context(parent, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := rctx.String(_targetNameFlag.Name); name != "BSC" {
+		t.Fatalf("target name want:BSC got:%s", name)
+	}
+	if !rctx.IsSet(_targetNameFlag.Name) {
+		t.Fatal("target name should be set")
+	}
+	if chain := rctx.Uint64(_srcChainFlag.Name); chain != 50001 {
+		t.Fatalf("chain should be inherited, got:%d", chain)
+	}
+}
+
+func TestRoutesShareSender(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	shared := newSharedConns()
+	syncValues := func(chain string) map[string]string {
+		return map[string]string{
+			_srcChainFlag.Name:      chain,
+			_syncTkmMCSFlag.Name:    "0000000000000000000000000000000000000001",
+			_syncTargetMCSFlag.Name: "0000000000000000000000000000000000000002",
+			_syncTargetLCFlag.Name:  "0000000000000000000000000000000000000003",
+		}
+	}
+	var locks []*storeLock
+	for _, rt := range []struct {
+		runnerType string
+		flags      []cli.Flag
+		values     map[string]string
+	}{
+		{"sync", _syncFlags, syncValues("50001")},
+		{"xsync", _xSyncFlags, map[string]string{
+			_xSyncMCSFlag.Name:       "0000000000000000000000000000000000000001",
+			_xSyncTargetMCSFlag.Name: "0000000000000000000000000000000000000002",
+			_xSyncTargetLCFlag.Name:  "0000000000000000000000000000000000000003",
+		}},
+		// the same type and target from another source chain
+		{"sync", _syncFlags, syncValues("50002")},
+	} {
+		h, err := newRunnerOfType(rt.runnerType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := h.base()
+		a.shared = shared
+		rt.values[_targetNameFlag.Name] = "BSC"
+		rt.values[_targetPrivFlag.Name] = testSenderKey
+		if err := h.prepareConfig(runnerTestContext(t, rt.flags, rt.values)); err != nil {
+			t.Fatal(err)
+		}
+		locks = append(locks, newStoreLock(store, a.keys.senderLockKey, a.keys.runnerLockValue, time.Minute))
+	}
+	values := make(map[string]bool)
+	for _, l := range locks {
+		if l.key != locks[0].key || values[l.value] {
+			t.Fatalf("same sender lock with different values expected: %s=%s", l.key, l.value)
+		}
+		values[l.value] = true
+	}
+	ctx := context.Background()
+	if _, err := locks[0].Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range locks[1:] {
+		if holder, err := l.Fetch(ctx); err == nil {
+			t.Fatalf("the sender lock should not be obtained by %s", l.value)
+		} else if holder != locks[0].value {
+			t.Fatalf("holder want:%s got:%s", locks[0].value, holder)
+		}
+	}
+}
@@ -0,0 +1,433 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	sc "sync"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/ThinkiumGroup/go-tkmrpc"
+	"github.com/ThinkiumGroup/go-tkmrpc/client"
+	"github.com/ThinkiumGroup/go-tkmrpc/models"
+	"github.com/stephenfire/go-rtl"
+	"google.golang.org/grpc/connectivity"
+)
+
+// tkmNode is one of the source TKM nodes
+type tkmNode struct {
+	addr string
+	conn *client.Client // nil if not connected
+}
+
+func (n *tkmNode) String() string {
+	return fmt.Sprintf("TKM@%s", n.addr)
+}
+
+// sourcePool connects to several TKM nodes of the same chain, and fails over between them.
+// When quorum>1, blocks will only be processed after at least quorum nodes returned the same
+// block hashes.
+type sourcePool struct {
+	chainId common.ChainID
+	nodes   []*tkmNode
+	quorum  int
+	current int // index of the node in use
+	lock    sc.Mutex
+}
+
+func newSourcePool(addrs []string, chainId common.ChainID, quorum int) (*sourcePool, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no source address")
+	}
+	if quorum > len(addrs) {
+		return nil, fmt.Errorf("quorum %d is more than the number of source nodes %d", quorum, len(addrs))
+	}
+	p := &sourcePool{chainId: chainId, quorum: quorum}
+	for _, addr := range addrs {
+		p.nodes = append(p.nodes, &tkmNode{addr: addr})
+	}
+	return p, nil
+}
+
+func (p *sourcePool) String() string {
+	if p == nil {
+		return "SourcePool<nil>"
+	}
+	return fmt.Sprintf("SourcePool{ChainID:%d Nodes:%d Quorum:%d}", p.chainId, len(p.nodes), p.quorum)
+}
+
+// required returns the least number of connected nodes for the pool to work
+func (p *sourcePool) required() int {
+	if p.quorum > 1 {
+		return p.quorum
+	}
+	return 1
+}
+
+func (p *sourcePool) _dial(ctx context.Context, node *tkmNode) (_ *client.Client, errr error) {
+	conn := &client.Client{
+		Server:       node.addr,
+		CurrentChain: p.chainId,
+	}
+	if err := conn.NewClient(); err != nil {
+		return nil, fmt.Errorf("connect %s failed: %w", node, err)
+	}
+	defer func() {
+		if errr != nil {
+			_ = conn.Close()
+		}
+	}()
+	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
+	defer cancel()
+	stats, err := conn.ChainStats(cctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s stats failed: %w", node, err)
+	}
+	if stats == nil || stats.ChainID != p.chainId {
+		return nil, fmt.Errorf("%s ChainID:%d required, but %s", node, p.chainId, stats.String())
+	}
+	log.Infof("%s connected: %s", node, stats)
+	return conn, nil
+}
+
+func (p *sourcePool) _alive(ctx context.Context, conn *client.Client) error {
+	if conn == nil || conn.NodeConn == nil {
+		return errors.New("not connected")
+	}
+	if state := conn.NodeConn.GetState(); state == connectivity.Shutdown {
+		return fmt.Errorf("connection state: %s", state)
+	}
+	cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
+	defer cancel()
+	stats, err := conn.ChainStats(cctx)
+	if err != nil {
+		return fmt.Errorf("tkm stats failed: %w", err)
+	}
+	if stats == nil || stats.ChainID != p.chainId {
+		return fmt.Errorf("ChainID:%d required, but %s", p.chainId, stats)
+	}
+	return nil
+}
+
+// _snapshot returns the nodes and their connections in the order of the nodes
+func (p *sourcePool) _snapshot() []*tkmNode {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	ret := make([]*tkmNode, len(p.nodes))
+	for i, node := range p.nodes {
+		ret[i] = &tkmNode{addr: node.addr, conn: node.conn}
+	}
+	return ret
+}
+
+// _swap replaces the connection of the i-th node with conn if it is still old, and closes the
+// replaced one. Returns false and closes conn if the connection was changed by another
+// goroutine meanwhile.
+func (p *sourcePool) _swap(i int, old, conn *client.Client) bool {
+	p.lock.Lock()
+	node := p.nodes[i]
+	swapped := node.conn == old
+	if swapped {
+		node.conn = conn
+	}
+	p.lock.Unlock()
+	// the calls still using the closed connection fail and turn to the other nodes
+	if swapped && old != nil {
+		_ = old.Close()
+	}
+	if !swapped && conn != nil {
+		_ = conn.Close()
+	}
+	return swapped
+}
+
+// connect connects all the nodes, returns error if less than required nodes are connected
+func (p *sourcePool) connect(ctx context.Context) error {
+	connected := 0
+	var errs []error
+	for i, node := range p._snapshot() {
+		if node.conn != nil {
+			connected++
+			continue
+		}
+		conn, err := p._dial(ctx, node)
+		if err != nil {
+			log.Warnf("%v", err)
+			errs = append(errs, err)
+			continue
+		}
+		// counted even if another goroutine connected the node first
+		p._swap(i, nil, conn)
+		connected++
+	}
+	if connected < p.required() {
+		return fmt.Errorf("%d of %d source nodes connected, %d required: %v", connected, len(p.nodes), p.required(), errs)
+	}
+	return nil
+}
+
+// alive checks every connected node and replaces the broken ones, returns error if less than
+// required nodes are alive. The nodes are checked and dialed without holding the lock of the
+// pool, so the calls on the other nodes are not blocked.
+func (p *sourcePool) alive(ctx context.Context) error {
+	alive := 0
+	for i, node := range p._snapshot() {
+		if node.conn == nil {
+			continue
+		}
+		if err := p._alive(ctx, node.conn); err != nil {
+			log.Warnf("%s broken: %v", node, err)
+			p._swap(i, node.conn, nil)
+			continue
+		}
+		alive++
+	}
+	if alive < len(p.nodes) {
+		// some nodes are broken, try to bring them back
+		for i, node := range p._snapshot() {
+			if node.conn != nil {
+				continue
+			}
+			if conn, err := p._dial(ctx, node); err == nil {
+				p._swap(i, nil, conn)
+				alive++
+			}
+		}
+	}
+	if alive < p.required() {
+		return fmt.Errorf("%d of %d source nodes alive, %d required", alive, len(p.nodes), p.required())
+	}
+	return nil
+}
+
+func (p *sourcePool) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, node := range p.nodes {
+		if node.conn != nil {
+			_ = node.conn.Close()
+			node.conn = nil
+		}
+	}
+	return nil
+}
+
+// _connected returns copies of the connected nodes, starting from the one in use. The copies
+// could be used while the connections are checked by another goroutine.
+func (p *sourcePool) _connected() []*tkmNode {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var ret []*tkmNode
+	for i := 0; i < len(p.nodes); i++ {
+		node := p.nodes[(p.current+i)%len(p.nodes)]
+		if node.conn != nil {
+			ret = append(ret, &tkmNode{addr: node.addr, conn: node.conn})
+		}
+	}
+	return ret
+}
+
+func (p *sourcePool) _failover(failed *tkmNode) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.nodes[p.current].addr == failed.addr {
+		p.current = (p.current + 1) % len(p.nodes)
+	}
+}
+
+// do calls fn with the connected nodes in turn until success. Every try has a timeout of
+// reqTimeOut.
+func (p *sourcePool) do(ctx context.Context, fn func(ctx context.Context, c *client.Client) error) error {
+	nodes := p._connected()
+	if len(nodes) == 0 {
+		return errors.New("no source node connected")
+	}
+	var err error
+	for _, node := range nodes {
+		cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
+		err = fn(cctx, node.conn)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		log.Warnf("%s failed: %v", node, err)
+		p._failover(node)
+	}
+	return err
+}
+
+func (p *sourcePool) ChainStats(ctx context.Context) (stats *models.ChainStats, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *client.Client) error {
+		stats, err = c.ChainStats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+func (p *sourcePool) Account(ctx context.Context, addr common.Address) (acc *client.AccountWithCode, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *client.Client) error {
+		acc, err = c.Account(ctx, addr)
+		return err
+	})
+	return acc, err
+}
+
+func (p *sourcePool) Receipt(ctx context.Context, txHash common.Hash) (rec *client.TransactionReceipt, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *client.Client) error {
+		rec, err = c.ReceiptByHash(ctx, txHash[:])
+		return err
+	})
+	return rec, err
+}
+
+func (p *sourcePool) LastConfirmedsAt(ctx context.Context, id common.ChainID, height common.Height) (cs *client.Confirmeds, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *client.Client) error {
+		cs, err = c.LastConfirmedsAt(ctx, id, height)
+		return err
+	})
+	return cs, err
+}
+
+func (p *sourcePool) Committee(ctx context.Context, epoch common.EpochNum) (nids []common.NodeID, err error) {
+	err = p.do(ctx, func(ctx context.Context, c *client.Client) error {
+		nids, err = c.Committee(ctx, epoch)
+		return err
+	})
+	return nids, err
+}
+
+func (p *sourcePool) _blocks(ctx context.Context, c *client.Client, start common.Height) (*client.RpcBlocks, error) {
+	resp, err := c.NodeClient.GetBlocks(ctx, &tkmrpc.RpcBlockHeight{
+		Chainid: uint32(p.chainId),
+		Height:  uint64(start),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get blocks starting at %d failed: %w", start, err)
+	}
+	if !resp.Success() {
+		log.Warnf("get blocks starting at %d failed, wait another fetch", start)
+		return nil, nil
+	}
+	blocks := new(client.RpcBlocks)
+	if err = rtl.Unmarshal(resp.Stream, blocks); err != nil {
+		return nil, fmt.Errorf("unmarshal blocks failed: %w", err)
+	}
+	return blocks, nil
+}
+
+// Blocks gets blocks starting at start. In quorum mode, only the leading blocks whose hashes are
+// confirmed by at least quorum nodes will be returned, and any different hash of the same height
+// returns an error.
+func (p *sourcePool) Blocks(ctx context.Context, start common.Height) (*client.RpcBlocks, error) {
+	if p.quorum <= 1 {
+		var blocks *client.RpcBlocks
+		err := p.do(ctx, func(ctx context.Context, c *client.Client) (err error) {
+			blocks, err = p._blocks(ctx, c, start)
+			return err
+		})
+		return blocks, err
+	}
+
+	nodes := p._connected()
+	if len(nodes) < p.quorum {
+		return nil, fmt.Errorf("%d source nodes connected, quorum %d required", len(nodes), p.quorum)
+	}
+	var primary *client.RpcBlocks
+	var primaryNode *tkmNode
+	var others []blockHashes
+	for _, node := range nodes {
+		cctx, cancel := context.WithTimeout(ctx, reqTimeOut)
+		blocks, err := p._blocks(cctx, node.conn, start)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			log.Warnf("%s failed: %v", node, err)
+			continue
+		}
+		if blocks == nil || len(blocks.Blocks) == 0 {
+			continue
+		}
+		if primary == nil {
+			primary, primaryNode = blocks, node
+		} else {
+			others = append(others, newBlockHashes(node.String(), blocks))
+		}
+	}
+	if primary == nil {
+		return nil, nil
+	}
+	n, err := quorumBlocks(newBlockHashes(primaryNode.String(), primary), others, p.quorum)
+	if err != nil {
+		return nil, err
+	}
+	if n < len(primary.Blocks) {
+		log.Infof("%d of %d blocks from %s confirmed by quorum %d", n, len(primary.Blocks), primaryNode, p.quorum)
+	}
+	primary.Blocks = primary.Blocks[:n]
+	return primary, nil
+}
+
+// blockHashes is the heights and hashes of blocks returned by one node
+type blockHashes struct {
+	node   string
+	hashes map[common.Height]common.Hash
+	order  []common.Height
+}
+
+func newBlockHashes(node string, blocks *client.RpcBlocks) blockHashes {
+	bh := blockHashes{node: node, hashes: make(map[common.Height]common.Hash)}
+	for _, block := range blocks.Blocks {
+		if block == nil || block.BlockHeader == nil {
+			break
+		}
+		h := block.BlockHeader.Height
+		bh.hashes[h] = block.BlockHeader.Hash()
+		bh.order = append(bh.order, h)
+	}
+	return bh
+}
+
+// quorumBlocks returns how many leading blocks of primary are confirmed by at least quorum nodes
+// (including the primary), or an error if another node returned a different block at the same
+// height.
+func quorumBlocks(primary blockHashes, others []blockHashes, quorum int) (int, error) {
+	for i, height := range primary.order {
+		hash := primary.hashes[height]
+		agreed := 1
+		for _, other := range others {
+			h, exist := other.hashes[height]
+			if !exist {
+				continue
+			}
+			if h != hash {
+				return 0, fmt.Errorf("block hash mismatch at Height:%s, %s:%x but %s:%x",
+					&height, primary.node, hash[:], other.node, h[:])
+			}
+			agreed++
+		}
+		if agreed < quorum {
+			return i, nil
+		}
+	}
+	return len(primary.order), nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-tkmrpc"
+	"github.com/ThinkiumGroup/go-tkmrpc/models"
+	"google.golang.org/grpc"
+)
+
+// stubNode is a TKM node only serving the chain stats, which are delayed while slow is set
+type stubNode struct {
+	tkmrpc.UnimplementedNodeServer
+	chainId atomic.Uint64
+	height  atomic.Uint64
+	slow    atomic.Bool
+}
+
+func (s *stubNode) GetStats(_ context.Context, _ *tkmrpc.RpcStatsReq) (*tkmrpc.RpcResponse, error) {
+	if s.slow.Load() {
+		time.Sleep(500 * time.Millisecond)
+	}
+	stats := &models.ChainStats{ChainID: common.ChainID(s.chainId.Load()), CurrentHeight: s.height.Load()}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return nil, err
+	}
+	return &tkmrpc.RpcResponse{Code: 0, Data: string(data)}, nil
+}
+
+// startStubNode serves the node on a local port until the test ends, and returns its address
+func startStubNode(t *testing.T, node *stubNode) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	tkmrpc.RegisterNodeServer(server, node)
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+func _testBlockHashes(node string, start common.Height, hashes ...byte) blockHashes {
+	bh := blockHashes{node: node, hashes: make(map[common.Height]common.Hash)}
+	for i, b := range hashes {
+		h := start + common.Height(i)
+		bh.hashes[h] = common.Hash{b}
+		bh.order = append(bh.order, h)
+	}
+	return bh
+}
+
+func TestQuorumBlocks(t *testing.T) {
+	primary := _testBlockHashes("A", 10, 1, 2, 3, 4)
+	tests := []struct {
+		others []blockHashes
+		quorum int
+		want   int
+		err    bool
+	}{
+		{others: nil, quorum: 1, want: 4},
+		{others: nil, quorum: 2, want: 0},
+		{others: []blockHashes{_testBlockHashes("B", 10, 1, 2)}, quorum: 2, want: 2},
+		{others: []blockHashes{_testBlockHashes("B", 10, 1, 2, 3, 4, 5)}, quorum: 2, want: 4},
+		{others: []blockHashes{_testBlockHashes("B", 10, 1, 2), _testBlockHashes("C", 10, 1, 2, 3)}, quorum: 3, want: 2},
+		{others: []blockHashes{_testBlockHashes("B", 11, 2, 3)}, quorum: 2, want: 0},
+		{others: []blockHashes{_testBlockHashes("B", 10, 1, 9)}, quorum: 2, err: true},
+	}
+	for i, test := range tests {
+		n, err := quorumBlocks(primary, test.others, test.quorum)
+		if test.err {
+			if err == nil {
+				t.Fatalf("#%d should fail", i)
+			}
+			t.Logf("#%d %v", i, err)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d failed: %v", i, err)
+		}
+		if n != test.want {
+			t.Fatalf("#%d want:%d got:%d", i, test.want, n)
+		}
+	}
+}
+
+func TestSourcePoolAlive(t *testing.T) {
+	nodeA, nodeB := &stubNode{}, &stubNode{}
+	nodeA.chainId.Store(50001)
+	nodeB.chainId.Store(50001)
+	src, err := newSourcePool([]string{startStubNode(t, nodeA), startStubNode(t, nodeB)}, 50001, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := src.connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = src.Close()
+	}()
+
+	// checking the slow node does not block the calls on the other one
+	nodeB.slow.Store(true)
+	done := make(chan error, 1)
+	go func() {
+		done <- src.alive(ctx)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if _, err := src.ChainStats(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 300*time.Millisecond {
+		t.Fatalf("blocked by the checking for %s", d)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	nodeB.slow.Store(false)
+
+	nodeB.chainId.Store(50002)
+	if err := src.alive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := src._connected(); len(nodes) != 1 {
+		t.Fatalf("the broken node should be closed, connected: %d", len(nodes))
+	}
+	nodeB.chainId.Store(50001)
+	if err := src.alive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if nodes := src._connected(); len(nodes) != 2 {
+		t.Fatalf("the node should be reconnected, connected: %d", len(nodes))
+	}
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-common/log"
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+type leaseStatus struct {
+	Key    string `json:"key"`
+	Holder string `json:"holder"`
+	TTLMs  int64  `json:"ttlMs"`
+	Error  string `json:"error,omitempty"`
+}
+
+type commStatus struct {
+	Name  string `json:"name"`
+	Epoch uint64 `json:"epoch"`
+	Match bool   `json:"match"`
+	Error string `json:"error,omitempty"`
+}
+
+// runnerStatus is the state of a configured runner, collected from the state store and both chains
+type runnerStatus struct {
+	Runner       string        `json:"runner"`
+	Type         string        `json:"type"`
+	Cursor       *uint64       `json:"cursor,omitempty"`
+	SourceHeight *uint64       `json:"sourceHeight,omitempty"`
+	RunningLock  *leaseStatus  `json:"runningLock,omitempty"`
+	SendingLock  *leaseStatus  `json:"sendingLock,omitempty"`
+	LastHeight   *uint64       `json:"lnLastHeight,omitempty"`
+	LastEpoch    *uint64       `json:"lnLastEpoch,omitempty"`
+	Committees   []*commStatus `json:"committees,omitempty"`
+	Sender       string        `json:"sender,omitempty"`
+	Balance      string        `json:"balance,omitempty"`
+	PendingNonce *uint64       `json:"pendingNonce,omitempty"`
+	LatestNonce  *uint64       `json:"latestNonce,omitempty"`
+	LastUpdate   *int64        `json:"lastUpdate,omitempty"`
+	NextUpdate   *int64        `json:"nextUpdate,omitempty"`
+	Errors       []string      `json:"errors,omitempty"`
+}
+
+func (s *runnerStatus) addError(format string, err error) {
+	if err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf(format, err))
+	}
+}
+
+// lightNodeStatus is implemented by the runners which concern the state of a light node
+type lightNodeStatus interface {
+	lightNodeStatus(cctx *cli.Context, status *runnerStatus)
+}
+
+func (a *runner) _leaseStatus(ctx context.Context, key string) *leaseStatus {
+	status := &leaseStatus{Key: key}
+	holder, err := a.store.LockHolder(ctx, key)
+	if err == nil && len(holder) > 0 {
+		var ttl time.Duration
+		ttl, err = a.store.LockTTL(ctx, key)
+		status.TTLMs = ttl.Milliseconds()
+	}
+	status.Holder = holder
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+func (a *runner) _senderStatus(ctx context.Context, status *runnerStatus) {
+	sender := a.targetPriv.Address()
+	status.Sender = sender.String()
+	pending, balance, err := a.target.nonceAndBalance(ctx, sender)
+	if err != nil {
+		status.addError("sender nonce and balance: %v", err)
+		return
+	}
+	status.PendingNonce = &pending
+	status.Balance = balance.String()
+	latest, err := a.target.confirmedNonce(ctx, sender)
+	if err != nil {
+		status.addError("sender latest nonce: %v", err)
+		return
+	}
+	status.LatestNonce = &latest
+}
+
+// status collects the state of the runner started by _withRunner
+func (a *runner) status(cctx *cli.Context, runnerType string, h cursorHandler) *runnerStatus {
+	status := &runnerStatus{Runner: a.String(), Type: runnerType}
+	ctx := cctx.Context
+	if cursor, _, err := h.getCursor(cctx); err != nil {
+		status.addError("cursor: %v", err)
+	} else if _, isUpdater := h.(*updater); !isUpdater {
+		status.Cursor = &cursor
+	}
+	if a.src != nil {
+		if stats, err := a._srcStats(cctx); err != nil {
+			status.addError("source stats: %v", err)
+		} else {
+			current := uint64(stats.CurrentHeight)
+			status.SourceHeight = &current
+		}
+	}
+	status.RunningLock = a._leaseStatus(ctx, a.keys.runnerLockKey)
+	if a.target != nil {
+		status.SendingLock = a._leaseStatus(ctx, a.keys.senderLockKey)
+		a._senderStatus(ctx, status)
+	}
+	if ln, ok := h.(lightNodeStatus); ok && a.target != nil {
+		ln.lightNodeStatus(cctx, status)
+	}
+	return status
+}
+
+func (a *maintainer) lightNodeStatus(cctx *cli.Context, status *runnerStatus) {
+	lastHeight, err := a._lastHeight(cctx.Context)
+	if err != nil {
+		status.addError("light-node lastHeight: %v", err)
+		return
+	}
+	h, epoch := uint64(lastHeight), uint64(lastHeight.EpochNum())
+	status.LastHeight, status.LastEpoch = &h, &epoch
+	for index := int64(0); index < 2; index++ {
+		comm := &commStatus{Name: fmt.Sprintf("%s[%d]", latest2EpochName, index)}
+		status.Committees = append(status.Committees, comm)
+		e, addrs, err := a._latestEpochComm(cctx.Context, index)
+		if err == nil && !e.IsNil() {
+			comm.Epoch = uint64(e)
+			comm.Match, err = _matchSourceComm(cctx.Context, a.src, e, addrs)
+		}
+		if err != nil {
+			comm.Error = err.Error()
+		}
+	}
+}
+
+func (a *xmaintainer) lightNodeStatus(cctx *cli.Context, status *runnerStatus) {
+	lastHeight, err := a._lastHeight(cctx.Context)
+	if err != nil {
+		status.addError("X-light-node lastHeight: %v", err)
+		return
+	}
+	h := uint64(lastHeight)
+	status.LastHeight = &h
+	lastEpoch, err := a._lastEpochInLN(cctx)
+	if err != nil {
+		status.addError("X-light-node lastEpoch: %v", err)
+		return
+	}
+	epoch := uint64(lastEpoch)
+	status.LastEpoch = &epoch
+	comm := &commStatus{Name: fmt.Sprintf("%s[1]", xEndsOfEpochName), Epoch: epoch}
+	status.Committees = append(status.Committees, comm)
+	addrs, err := a._commAtEpochInLN(cctx, lastEpoch)
+	if err == nil {
+		comm.Match, err = _matchSourceComm(cctx.Context, a.src, lastEpoch, addrs)
+	}
+	if err != nil {
+		comm.Error = err.Error()
+	}
+}
+
+func (u *updater) lightNodeStatus(cctx *cli.Context, status *runnerStatus) {
+	if last, err := u._lastUpdateTimeInCache(cctx.Context); err != nil {
+		status.addError("last update time: %v", err)
+	} else {
+		status.LastUpdate = &last
+		if u.conf.Updater.Interval > 1 {
+			next := nextUpdateTime(last, int64(u.conf.Updater.Interval))
+			status.NextUpdate = &next
+		}
+	}
+	lastEpoch, err := u._lastEpochInLC(cctx.Context)
+	if err != nil {
+		status.addError("updatable light-node lastEpoch: %v", err)
+		return
+	}
+	epoch := uint64(lastEpoch)
+	status.LastEpoch = &epoch
+	comm := &commStatus{Name: uLastEpochName, Epoch: epoch}
+	status.Committees = append(status.Committees, comm)
+	addrs, err := u._lastCommitteeInLC(cctx.Context)
+	if err == nil {
+		comm.Match, err = _matchSourceComm(cctx.Context, u.src, lastEpoch, addrs)
+	}
+	if err != nil {
+		comm.Error = err.Error()
+	}
+}
+
+func _matchSourceComm(ctx context.Context, src *sourcePool, epoch common.EpochNum, addrs []common.Address) (bool, error) {
+	if src == nil {
+		return false, errors.New("source not connected")
+	}
+	srcComm, err := getSourceCommOfEpoch(ctx, src, epoch)
+	if err != nil {
+		return false, fmt.Errorf("src.Committee(epoch:%d) failed: %w", epoch, err)
+	}
+	return committeeEquals(srcComm, addrs), nil
+}
+
+// nextUpdateTime returns the first canonical time after the last update, at which the updater
+// would update the committee
+func nextUpdateTime(lastUpdate, interval int64) int64 {
+	return (lastUpdate/interval + 1) * interval
+}
+
+func _optUint(v *uint64) string {
+	if v == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d", *v)
+}
+
+func (l *leaseStatus) String() string {
+	if l == nil {
+		return "-"
+	}
+	holder := l.Holder
+	if len(holder) == 0 {
+		holder = "<free>"
+	} else {
+		holder = fmt.Sprintf("%s ttl:%s", holder, time.Duration(l.TTLMs)*time.Millisecond)
+	}
+	if len(l.Error) > 0 {
+		holder += " error:" + l.Error
+	}
+	return fmt.Sprintf("%s\t%s", holder, l.Key)
+}
+
+// writeTable writes the status as a table of name-value pairs
+func (s *runnerStatus) writeTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "RUNNER\t%s\t%s\n", s.Runner, s.Type)
+	if s.Cursor != nil || s.SourceHeight != nil {
+		lag := "-"
+		if s.Cursor != nil && s.SourceHeight != nil {
+			lag = fmt.Sprintf("%d", int64(*s.SourceHeight)-int64(*s.Cursor))
+		}
+		fmt.Fprintf(w, "cursor\t%s / %s\tlag:%s\n", _optUint(s.Cursor), _optUint(s.SourceHeight), lag)
+	}
+	fmt.Fprintf(w, "runningLock\t%s\n", s.RunningLock)
+	if s.SendingLock != nil {
+		fmt.Fprintf(w, "sendingLock\t%s\n", s.SendingLock)
+	}
+	if s.LastHeight != nil || s.LastEpoch != nil {
+		fmt.Fprintf(w, "light-node\tlastHeight:%s lastEpoch:%s\n", _optUint(s.LastHeight), _optUint(s.LastEpoch))
+	}
+	for _, comm := range s.Committees {
+		result := "MATCH"
+		switch {
+		case len(comm.Error) > 0:
+			result = "ERROR " + comm.Error
+		case !comm.Match:
+			result = "MISMATCH"
+		}
+		fmt.Fprintf(w, "committee\t%s=epoch:%d\t%s\n", comm.Name, comm.Epoch, result)
+	}
+	if len(s.Sender) > 0 {
+		fmt.Fprintf(w, "sender\t%s\tbalance:%s\n", s.Sender, s.Balance)
+		fmt.Fprintf(w, "nonce\tpending:%s latest:%s\n", _optUint(s.PendingNonce), _optUint(s.LatestNonce))
+	}
+	if s.LastUpdate != nil {
+		next := "on demand"
+		if s.NextUpdate != nil {
+			next = unixSecondsString(*s.NextUpdate)
+		}
+		fmt.Fprintf(w, "update\tlast:%s\tnext:%s\n", unixSecondsString(*s.LastUpdate), next)
+	}
+	for _, e := range s.Errors {
+		fmt.Fprintf(w, "error\t%s\n", e)
+	}
+	return w.Flush()
+}
+
+func status(cctx *cli.Context) error {
+	asJSON := cctx.Bool(_statusJSONFlag.Name)
+	if root, ok := log.WithField().(*logrus.Logger); ok && asJSON {
+		// keep stdout for the JSON only
+		root.SetOutput(os.Stderr)
+	}
+	var statuses []*runnerStatus
+	for _, r := range _runnerTypes {
+		if len(cctx.String(r.contract)) == 0 {
+			continue
+		}
+		runnerType := r.name
+		var s *runnerStatus
+		err := _withRunner(cctx, runnerType, func(h cursorHandler) error {
+			s = h.base().status(cctx, runnerType, h)
+			return nil
+		})
+		if s == nil {
+			name := strings.ToUpper(runnerType + "_" + cctx.String(_targetNameFlag.Name))
+			s = &runnerStatus{Runner: name, Type: runnerType}
+			s.addError("start: %v", err)
+		}
+		statuses = append(statuses, s)
+	}
+	if len(statuses) == 0 {
+		return checkerror(cli.Exit(errors.New("no runner configured"), ExitByConfig))
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(statuses)
+	}
+	for i, s := range statuses {
+		if i > 0 {
+			fmt.Println()
+		}
+		if err := s.writeTable(os.Stdout); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestNextUpdateTime(t *testing.T) {
+	if next := nextUpdateTime(1000, 600); next != 1200 {
+		t.Fatalf("next want:1200 got:%d", next)
+	}
+	if next := nextUpdateTime(1200, 600); next != 1800 {
+		t.Fatalf("next want:1800 got:%d", next)
+	}
+}
+
+func TestStatusTable(t *testing.T) {
+	cursor, head, pending, latest := uint64(100), uint64(160), uint64(12), uint64(10)
+	s := &runnerStatus{
+		Runner:       "SYNC_BSC",
+		Type:         "sync",
+		Cursor:       &cursor,
+		SourceHeight: &head,
+		RunningLock:  &leaseStatus{Key: "sync_bsc_lock_50001", Holder: "10.0.0.1@1", TTLMs: 25000},
+		SendingLock:  &leaseStatus{Key: "targetSender_97_0x01"},
+		Committees:   []*commStatus{{Name: "latest2Epoch[0]", Epoch: 3, Match: false}},
+		Sender:       "0x01",
+		Balance:      "1000",
+		PendingNonce: &pending,
+		LatestNonce:  &latest,
+	}
+	buf := new(bytes.Buffer)
+	if err := s.writeTable(buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	t.Log("\n" + out)
+	for _, want := range []string{"lag:60", "10.0.0.1@1 ttl:25s", "<free>", "MISMATCH", "pending:12 latest:10"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("%q missing", want)
+		}
+	}
+}
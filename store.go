@@ -0,0 +1,469 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const fenceKeySuffix = "_fence"
+
+var (
+	ErrLockNotHeld = errors.New("lock not held")
+	ErrStaleToken  = errors.New("fencing token is stale")
+)
+
+// StateStore keeps the states shared by the runners of the same source and target: the start
+// heights (cursors), the locks and other values.
+type StateStore interface {
+	// Cursor returns the height saved at key, exist is false if not saved yet
+	Cursor(ctx context.Context, key string) (value uint64, exist bool, err error)
+	SetCursor(ctx context.Context, key string, value uint64) error
+
+	Get(ctx context.Context, key string) (value string, exist bool, err error)
+	Set(ctx context.Context, key string, value string) error
+	Del(ctx context.Context, keys ...string) error
+	// Incr increases the integer at key by 1 and returns the new value, a missing key is 0
+	Incr(ctx context.Context, key string) (uint64, error)
+
+	// HSet/HGet/HDel/HGetAll access the fields of the map saved at key
+	HSet(ctx context.Context, key, field, value string) error
+	HGet(ctx context.Context, key, field string) (value string, exist bool, err error)
+	HDel(ctx context.Context, key string, fields ...string) error
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+
+	// ObtainLock sets value to the lock at key for ttl, if the lock is free or has already been
+	// held by value. Returns the holder of the lock, it is obtained only if holder == value.
+	ObtainLock(ctx context.Context, key, value string, ttl time.Duration) (holder string, err error)
+	// RefreshLock extends the ttl of the lock, returns ErrLockNotHeld if it is not held by value
+	RefreshLock(ctx context.Context, key, value string, ttl time.Duration) error
+	// ReleaseLock frees the lock, returns ErrLockNotHeld if it is not held by value
+	ReleaseLock(ctx context.Context, key, value string) error
+	// LockHolder returns the current holder of the lock, empty string for a free one
+	LockHolder(ctx context.Context, key string) (string, error)
+	// LockTTL returns the remaining ttl of the lock, 0 for a free one
+	LockTTL(ctx context.Context, key string) (time.Duration, error)
+
+	Ping(ctx context.Context) error
+	// Describe returns the type and version of the store for logging
+	Describe(ctx context.Context) string
+	Close() error
+}
+
+func newStateStore(conf *Config) (StateStore, error) {
+	if len(conf.StoreFile) > 0 {
+		return newFileStore(conf.StoreFile)
+	}
+	return newRedisStore(conf.RedisAddr)
+}
+
+func parseCursor(value string) (uint64, error) {
+	h, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cursor %q: %w", value, err)
+	}
+	return h, nil
+}
+
+const (
+	// same as redislock, a holder can re-obtain its lock
+	luaObtainSrc = `local v = redis.call("get", KEYS[1])
+if v == false or v == ARGV[1] then
+	redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return ARGV[1]
+end
+return v`
+	luaRefreshSrc = `if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0`
+	luaReleaseSrc = `if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`
+)
+
+var (
+	luaObtain  = redis.NewScript(luaObtainSrc)
+	luaRefresh = redis.NewScript(luaRefreshSrc)
+	luaRelease = redis.NewScript(luaReleaseSrc)
+)
+
+const (
+	redisSingle   = "single"
+	redisSentinel = "sentinel"
+	redisCluster  = "cluster"
+
+	redisSentinelScheme = "redis-sentinel"
+	redisClusterScheme  = "redis-cluster"
+
+	redisWaitTimeout = 500 * time.Millisecond
+)
+
+var ErrNotReplicated = errors.New("write not acknowledged by enough replicas")
+
+// redisConfig is parsed from the redis flag, which is one of:
+//
+//	redis://[[user]:password@]host:port[/db][?options]    a single node, options of redis.ParseURL
+//	redis-sentinel://[[user]:password@]host:port[,host:port...][/db]?master=NAME[&sentinel_password=PWD]
+//	redis-cluster://[[user]:password@]host:port[,host:port...]
+//
+// Sentinel and single node also accept wait=N, to wait for N replicas acknowledging the writes of
+// locks and fencing tokens, so that they survive a failover.
+type redisConfig struct {
+	mode string
+	opts *redis.UniversalOptions
+	wait int
+}
+
+func parseRedisURL(redisURL string) (*redisConfig, error) {
+	u, err := url.Parse(redisURL)
+	if err != nil {
+		return nil, err
+	}
+	query := u.Query()
+	wait := 0
+	if w := query.Get("wait"); len(w) > 0 {
+		if wait, err = strconv.Atoi(w); err != nil || wait < 0 {
+			return nil, fmt.Errorf("invalid wait: %s", w)
+		}
+		query.Del("wait")
+	}
+
+	switch u.Scheme {
+	case redisSentinelScheme, redisClusterScheme:
+	default:
+		u.RawQuery = query.Encode()
+		opts, err := redis.ParseURL(u.String())
+		if err != nil {
+			return nil, err
+		}
+		return &redisConfig{
+			mode: redisSingle,
+			opts: &redis.UniversalOptions{
+				Addrs:        []string{opts.Addr},
+				ClientName:   opts.ClientName,
+				DB:           opts.DB,
+				Username:     opts.Username,
+				Password:     opts.Password,
+				MaxRetries:   opts.MaxRetries,
+				DialTimeout:  opts.DialTimeout,
+				ReadTimeout:  opts.ReadTimeout,
+				WriteTimeout: opts.WriteTimeout,
+				PoolSize:     opts.PoolSize,
+				PoolTimeout:  opts.PoolTimeout,
+				MinIdleConns: opts.MinIdleConns,
+				MaxIdleConns: opts.MaxIdleConns,
+				TLSConfig:    opts.TLSConfig,
+			},
+			wait: wait,
+		}, nil
+	}
+
+	addrs := splitAddrs(u.Host)
+	if len(addrs) == 0 {
+		return nil, errors.New("address missing")
+	}
+	opts := &redis.UniversalOptions{Addrs: addrs}
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); len(db) > 0 {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database number: %s", db)
+		}
+	}
+	conf := &redisConfig{opts: opts, wait: wait}
+	if u.Scheme == redisSentinelScheme {
+		conf.mode = redisSentinel
+		opts.MasterName = query.Get("master")
+		opts.SentinelUsername = query.Get("sentinel_username")
+		opts.SentinelPassword = query.Get("sentinel_password")
+		if len(opts.MasterName) == 0 {
+			return nil, errors.New("master name of sentinel missing")
+		}
+	} else {
+		conf.mode = redisCluster
+		if opts.DB != 0 {
+			return nil, errors.New("redis cluster only supports database 0")
+		}
+		if wait > 0 {
+			// WAIT is for the writes of the same connection, which can't be ensured by cluster client
+			return nil, errors.New("wait is not supported in cluster mode")
+		}
+	}
+	return conf, nil
+}
+
+func (c *redisConfig) newClient() redis.UniversalClient {
+	switch c.mode {
+	case redisSentinel:
+		return redis.NewFailoverClient(c.opts.Failover())
+	case redisCluster:
+		return redis.NewClusterClient(c.opts.Cluster())
+	default:
+		return redis.NewClient(c.opts.Simple())
+	}
+}
+
+// hashTagged puts the key in a hash tag without the suffixes of lock keys, so that the lock, its
+// fencing token and the tx journal of a sender are in the same slot of a cluster.
+func hashTagged(key string) string {
+	if strings.ContainsAny(key, "{}") {
+		return key
+	}
+	base := key
+	for _, suffix := range []string{fenceKeySuffix, journalKeySuffix} {
+		base = strings.TrimSuffix(base, suffix)
+	}
+	return "{" + base + "}" + key[len(base):]
+}
+
+type redisStore struct {
+	conf   *redisConfig
+	client redis.UniversalClient
+}
+
+func newRedisStore(redisURL string) (*redisStore, error) {
+	conf, err := parseRedisURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("redis URL parse failed: %w", err)
+	}
+	return &redisStore{conf: conf, client: conf.newClient()}, nil
+}
+
+func (s *redisStore) String() string {
+	if s.conf.mode == redisSentinel {
+		return fmt.Sprintf("RedisStore{%s %s@%s/%d}", s.conf.mode, s.conf.opts.MasterName,
+			strings.Join(s.conf.opts.Addrs, ","), s.conf.opts.DB)
+	}
+	return fmt.Sprintf("RedisStore{%s %s/%d}", s.conf.mode, strings.Join(s.conf.opts.Addrs, ","), s.conf.opts.DB)
+}
+
+func (s *redisStore) _key(key string) string {
+	if s.conf.mode == redisCluster {
+		return hashTagged(key)
+	}
+	return key
+}
+
+// _replicated runs cmd, and waits for the write to be acknowledged by the replicas if needed. They
+// are pipelined in one connection, as WAIT only works for the writes of its own connection.
+func (s *redisStore) _replicated(ctx context.Context, cmd func(c redis.Cmdable) redis.Cmder) error {
+	if s.conf.wait <= 0 {
+		return cmd(s.client).Err()
+	}
+	var waitCmd *redis.Cmd
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		cmd(pipe)
+		waitCmd = pipe.Do(ctx, "WAIT", s.conf.wait, redisWaitTimeout.Milliseconds())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if n, _ := waitCmd.Int64(); n < int64(s.conf.wait) {
+		return fmt.Errorf("%w: %d of %d", ErrNotReplicated, n, s.conf.wait)
+	}
+	return nil
+}
+
+func (s *redisStore) Cursor(ctx context.Context, key string) (uint64, bool, error) {
+	value, exist, err := s.Get(ctx, key)
+	if err != nil || !exist {
+		return 0, exist, err
+	}
+	h, err := parseCursor(value)
+	return h, true, err
+}
+
+func (s *redisStore) SetCursor(ctx context.Context, key string, value uint64) error {
+	return s.Set(ctx, key, strconv.FormatUint(value, 10))
+}
+
+func (s *redisStore) Get(ctx context.Context, key string) (string, bool, error) {
+	value, err := s.client.Get(ctx, s._key(key)).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	default:
+		return value, true, nil
+	}
+}
+
+func (s *redisStore) Set(ctx context.Context, key string, value string) error {
+	return s.client.Set(ctx, s._key(key), value, 0).Err()
+}
+
+// Del deletes the keys one by one, which may be in different slots of a cluster
+func (s *redisStore) Del(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := s.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, s._key(key))
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *redisStore) Incr(ctx context.Context, key string) (uint64, error) {
+	var incr *redis.IntCmd
+	if err := s._replicated(ctx, func(c redis.Cmdable) redis.Cmder {
+		incr = c.Incr(ctx, s._key(key))
+		return incr
+	}); err != nil {
+		return 0, err
+	}
+	return uint64(incr.Val()), nil
+}
+
+func (s *redisStore) HSet(ctx context.Context, key, field, value string) error {
+	return s.client.HSet(ctx, s._key(key), field, value).Err()
+}
+
+func (s *redisStore) HGet(ctx context.Context, key, field string) (string, bool, error) {
+	value, err := s.client.HGet(ctx, s._key(key), field).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return "", false, nil
+	case err != nil:
+		return "", false, err
+	default:
+		return value, true, nil
+	}
+}
+
+func (s *redisStore) HDel(ctx context.Context, key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return s.client.HDel(ctx, s._key(key), fields...).Err()
+}
+
+func (s *redisStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return s.client.HGetAll(ctx, s._key(key)).Result()
+}
+
+// _script runs the lua script, pipelined scripts are sent by EVAL for no NOSCRIPT error
+func (s *redisStore) _script(ctx context.Context, script *redis.Script, src string, key string,
+	args ...interface{}) (*redis.Cmd, error) {
+	var cmd *redis.Cmd
+	err := s._replicated(ctx, func(c redis.Cmdable) redis.Cmder {
+		if s.conf.wait > 0 {
+			cmd = c.Eval(ctx, src, []string{s._key(key)}, args...)
+		} else {
+			cmd = script.Run(ctx, c, []string{s._key(key)}, args...)
+		}
+		return cmd
+	})
+	return cmd, err
+}
+
+func (s *redisStore) ObtainLock(ctx context.Context, key, value string, ttl time.Duration) (string, error) {
+	c, err := s._script(ctx, luaObtain, luaObtainSrc, key, value, ttl.Milliseconds())
+	if err != nil {
+		return "", err
+	}
+	return c.Text()
+}
+
+func (s *redisStore) RefreshLock(ctx context.Context, key, value string, ttl time.Duration) error {
+	c, err := s._script(ctx, luaRefresh, luaRefreshSrc, key, value, ttl.Milliseconds())
+	if err != nil {
+		return err
+	}
+	if n, _ := c.Int64(); n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (s *redisStore) ReleaseLock(ctx context.Context, key, value string) error {
+	n, err := luaRelease.Run(ctx, s.client, []string{s._key(key)}, value).Int64()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (s *redisStore) LockHolder(ctx context.Context, key string) (string, error) {
+	holder, _, err := s.Get(ctx, key)
+	return holder, err
+}
+
+func (s *redisStore) LockTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.client.PTTL(ctx, s._key(key)).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		// -2 for a missing key, and -1 for a key without ttl which should not be a lock
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (s *redisStore) Ping(ctx context.Context) error {
+	status := s.client.Ping(ctx)
+	if status.Val() != "PONG" {
+		return fmt.Errorf("ping redis server failed: %v", status.Err())
+	}
+	return nil
+}
+
+func (s *redisStore) Describe(ctx context.Context) string {
+	info := s.client.Info(ctx, "server")
+	r := bufio.NewReader(bytes.NewBufferString(info.Val()))
+	version := ""
+	for i := 0; i < 20; i++ {
+		line, _, err := r.ReadLine()
+		if len(line) > 0 {
+			if strings.Contains(string(line), "redis_version") {
+				parts := strings.Split(string(line), ":")
+				if len(parts) > 1 {
+					version = strings.TrimSpace(parts[1])
+				}
+				break
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return fmt.Sprintf("%s version: %s wait: %d", s, version, s.conf.wait)
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
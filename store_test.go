@@ -0,0 +1,225 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFileStore(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	ctx := context.Background()
+	if err := store.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, exist, err := store.Cursor(ctx, "start"); err != nil || exist {
+		t.Fatalf("cursor should not exist: %t %v", exist, err)
+	}
+	if err := store.SetCursor(ctx, "start", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if h, exist, err := store.Cursor(ctx, "start"); err != nil || !exist || h != 1000 {
+		t.Fatalf("cursor want:1000 got:%d %t %v", h, exist, err)
+	}
+
+	if err := store.HSet(ctx, "journal", "0x01", "a"); err != nil {
+		t.Fatal(err)
+	}
+	_ = store.HSet(ctx, "journal", "0x02", "b")
+	_ = store.HSet(ctx, "journal2", "0x03", "c")
+	if all, err := store.HGetAll(ctx, "journal"); err != nil || len(all) != 2 || all["0x02"] != "b" {
+		t.Fatalf("HGetAll failed: %v %v", all, err)
+	}
+	_ = store.HDel(ctx, "journal", "0x01")
+	if _, exist, _ := store.HGet(ctx, "journal", "0x01"); exist {
+		t.Fatal("0x01 should be deleted")
+	}
+	if err := store.Del(ctx, "start", "journal"); err != nil {
+		t.Fatal(err)
+	}
+	if all, _ := store.HGetAll(ctx, "journal"); len(all) != 0 {
+		t.Fatalf("journal should be deleted: %v", all)
+	}
+	if all, _ := store.HGetAll(ctx, "journal2"); len(all) != 1 {
+		t.Fatalf("journal2 should not be deleted: %v", all)
+	}
+}
+
+func TestFileStoreLock(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	l1 := newStoreLock(store, "lock", "10.0.0.1@1", 30*time.Second)
+	l2 := newStoreLock(store, "lock", "10.0.0.2@2", 30*time.Second)
+	if _, err := l1.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := l2.FetchOrRefresh(ctx); err == nil || holder != "10.0.0.1@1" {
+		t.Fatalf("l2 should not obtain the lock held by %s: %v", holder, err)
+	}
+	if err := store.RefreshLock(ctx, "lock", "10.0.0.2@2", time.Second); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("refresh by others should fail, got %v", err)
+	}
+
+	now = now.Add(20 * time.Second)
+	if err := l1.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(20 * time.Second)
+	if holder, _ := store.LockHolder(ctx, "lock"); holder != "10.0.0.1@1" {
+		t.Fatalf("lock should be refreshed, holder: %q", holder)
+	}
+	if ttl, _ := store.LockTTL(ctx, "lock"); ttl.Round(time.Second) != 10*time.Second {
+		t.Fatalf("ttl want:10s got:%s", ttl)
+	}
+
+	now = now.Add(11 * time.Second)
+	if holder, err := l2.FetchOrRefresh(ctx); err != nil || holder != "10.0.0.2@2" {
+		t.Fatalf("l2 should obtain the expired lock: %s %v", holder, err)
+	}
+	if err := l1.Refresh(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("l1 lost the lock, got %v", err)
+	}
+	if err := l2.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _ := store.LockHolder(ctx, "lock"); holder != "" {
+		t.Fatalf("lock should be free, holder: %q", holder)
+	}
+}
+
+func TestFencingToken(t *testing.T) {
+	store, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	l1 := newStoreLock(store, "sender", "10.0.0.1@1", 30*time.Second)
+	if _, err := l1.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if l1.Token() != 1 {
+		t.Fatalf("token want:1 got:%d", l1.Token())
+	}
+	if err := l1.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// l1 stalled past the TTL, and l2 fetched the lock
+	now = now.Add(31 * time.Second)
+	l2 := newStoreLock(store, "sender", "10.0.0.2@2", 30*time.Second)
+	if _, err := l2.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if l2.Token() != 2 {
+		t.Fatalf("token want:2 got:%d", l2.Token())
+	}
+	if err := (storeLocks{l1}).Validate(ctx); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("l1 should be invalid, got %v", err)
+	}
+	_ = l2.Release()
+
+	// the same holder value fetched by another lock instance
+	l3 := newStoreLock(store, "sender", "10.0.0.1@1", 30*time.Second)
+	if _, err := l3.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l1.Validate(ctx); !errors.Is(err, ErrStaleToken) {
+		t.Fatalf("token of l1 should be stale, got %v", err)
+	}
+	if err := l3.Validate(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseRedisURL(t *testing.T) {
+	conf, err := parseRedisURL("redis://:pwd@127.0.0.1:6380/2?wait=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.mode != redisSingle || conf.opts.Addrs[0] != "127.0.0.1:6380" || conf.opts.DB != 2 ||
+		conf.opts.Password != "pwd" || conf.wait != 1 {
+		t.Fatalf("invalid single: %s %+v wait:%d", conf.mode, conf.opts, conf.wait)
+	}
+
+	conf, err = parseRedisURL("redis-sentinel://:pwd@10.0.0.1:26379,10.0.0.2:26379/1?master=mymaster&sentinel_password=spwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.mode != redisSentinel || len(conf.opts.Addrs) != 2 || conf.opts.MasterName != "mymaster" ||
+		conf.opts.DB != 1 || conf.opts.Password != "pwd" || conf.opts.SentinelPassword != "spwd" {
+		t.Fatalf("invalid sentinel: %s %+v", conf.mode, conf.opts)
+	}
+
+	conf, err = parseRedisURL("redis-cluster://10.0.0.1:7000,10.0.0.2:7000,10.0.0.3:7000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.mode != redisCluster || len(conf.opts.Addrs) != 3 {
+		t.Fatalf("invalid cluster: %s %+v", conf.mode, conf.opts)
+	}
+
+	for _, invalid := range []string{
+		"redis-sentinel://10.0.0.1:26379",
+		"redis-cluster://10.0.0.1:7000/1",
+		"redis-cluster://10.0.0.1:7000?wait=1",
+		"redis://127.0.0.1:6379?wait=-1",
+	} {
+		if _, err := parseRedisURL(invalid); err == nil {
+			t.Fatalf("%s should be invalid", invalid)
+		} else {
+			t.Logf("%s: %v", invalid, err)
+		}
+	}
+}
+
+func TestHashTagged(t *testing.T) {
+	lockKey := "sender_lock_97_0x01"
+	tests := map[string]string{
+		lockKey:                    "{sender_lock_97_0x01}",
+		lockKey + fenceKeySuffix:   "{sender_lock_97_0x01}" + fenceKeySuffix,
+		lockKey + journalKeySuffix: "{sender_lock_97_0x01}" + journalKeySuffix,
+		"{tagged}_start":           "{tagged}_start",
+	}
+	for key, want := range tests {
+		if got := hashTagged(key); got != want {
+			t.Fatalf("%s want:%s got:%s", key, want, got)
+		}
+	}
+}
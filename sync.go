@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
+	"time"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/log"
@@ -47,6 +48,7 @@ type syncer struct {
 	looper
 	watchTopicId       common.Hash
 	maxProvableHeights *Expirable[*provableHeights]
+	filter             *txFilter
 }
 
 func (n *syncer) Name() string {
@@ -60,8 +62,8 @@ func (n *syncer) prepareConfig(ctx *cli.Context) error {
 	if err := n.looper.prepareConfig(ctx); err != nil {
 		return err
 	}
-	n.keys.startHeightKey = fmt.Sprintf("%s_start_%d", strings.ToLower(n.Name()), n.conf.SrcChainId)
-	n.keys.runnerLockKey = fmt.Sprintf("%s_lock_%d", strings.ToLower(n.Name()), n.conf.SrcChainId)
+	n.keys.startHeightKey = n.keyBuilder().startHeight(n.Name(), n.conf.SrcChainId)
+	n.keys.runnerLockKey = n.keyBuilder().runnerLock(n.Name(), n.conf.SrcChainId)
 	log.Infof("%s", n.keys)
 
 	tkmMcs, err := stringToAddress(ctx, _syncTkmMCSFlag.Name)
@@ -82,6 +84,12 @@ func (n *syncer) prepareConfig(ctx *cli.Context) error {
 	n.conf.Synchronizer.TargetLCAddr = targetlc
 	n.conf.Synchronizer.UpdatableLC = ctx.Bool(_syncUpdatableLCFlag.Name)
 	n.conf.Synchronizer.MaxHeightTTL = int64(ctx.Uint64(_syncMaxHeightTTLFlag.Name))
+	n.conf.Synchronizer.Preflight = ctx.Bool(_syncPreflightFlag.Name)
+	routers, err := stringToAddresses(ctx, _syncRoutersFlag.Name)
+	if err != nil {
+		return err
+	}
+	n.conf.Synchronizer.Routers = routers
 
 	if err := n.conf.Synchronizer.validate(); err != nil {
 		return err
@@ -104,6 +112,10 @@ func (n *syncer) prepareConfig(ctx *cli.Context) error {
 	}
 	n.watchTopicId = event.ID
 	log.Infof("watching: Address:%x EventTopic:%x", n.conf.Synchronizer.TkmMCSAddress[:], n.watchTopicId[:])
+	n.filter, err = newTxFilter(n.conf.SrcPrefilter, n.conf.Synchronizer.TkmMCSAddress, n.watchTopicId, n.conf.Synchronizer.Routers)
+	if err != nil {
+		return cli.Exit(err, ExitByConfig)
+	}
 	return nil
 }
 
@@ -126,9 +138,7 @@ func (n *syncer) confirmConfig(ctx *cli.Context) error {
 }
 
 func (n *syncer) _targetMustContract(cctx *cli.Context, addr common.Address) bool {
-	ctx, cancel := context.WithCancel(cctx.Context)
-	defer cancel()
-	code, err := n.target.Client.CodeAt(ctx, T2E.Address(addr), nil)
+	code, err := n.target.getCode(cctx.Context, addr)
 	if err != nil {
 		return false
 	}
@@ -160,25 +170,36 @@ func (n *syncer) processBlock(cctx *cli.Context, block *models.BlockEMessage) (f
 			return NotUnlockError(fmt.Errorf("max provable height exceeded: Main:%s, Sub:%s, but Block.Height:%s",
 				&maxMain, &maxSub, &block.BlockHeader.Height)), nil
 		}
-		var txproofs []*models.TxFinalProof
-		for _, tx := range block.BlockBody.Txs {
-			if tx.To != nil && len(tx.Input) > 0 {
-				_ = n.runningLock.Refresh(cctx.Context)
+		candidates, err := n._prefilter(cctx.Context, n.filter, block)
+		if err != nil {
+			return err, nil
+		}
+		proofs, err := fetchOrdered(cctx.Context, n.conf.SrcConcurrency, len(candidates),
+			func(ctx context.Context, i int) (*models.TxFinalProof, error) {
+				tx := candidates[i]
 				txHash := tx.Hash()
-				proof, err := n._txFinalProof(cctx.Context, n.conf.SrcChainId, txHash, maxMain)
+				proof, err := n._txFinalProof(ctx, n.conf.SrcChainId, txHash, maxMain)
 				if err != nil || proof == nil {
-					return fmt.Errorf("get final proof of TxHash:%x failed: %w", txHash[:], err), nil
+					return nil, fmt.Errorf("get final proof of TxHash:%x failed: %w", txHash[:], err)
+				}
+				if proof.Receipt == nil {
+					return nil, fmt.Errorf("get receipt of TxHash:%x failed", txHash[:])
 				}
 				if !proof.Receipt.Success() {
 					log.Debugf("%s failed", tx)
-					continue
+					return nil, nil
 				}
 				if err := proof.FinalVerify(); err != nil {
-					return fmt.Errorf("final proof %s verify failed: %w", proof, err), nil
-				}
-				if proof.Receipt == nil {
-					return fmt.Errorf("get receipt of TxHash:%x failed", txHash[:]), nil
+					return nil, fmt.Errorf("final proof %s verify failed: %w", proof, err)
 				}
+				return proof, nil
+			})
+		if err != nil {
+			return err, nil
+		}
+		var txproofs []*models.TxFinalProof
+		for _, proof := range proofs {
+			if proof != nil {
 				if i, rlog := locateLog(proof.Receipt.Logs, n.conf.Synchronizer.TkmMCSAddress, n.watchTopicId); i >= 0 {
 					out := new(MapTransferOutLog)
 					if err := MCSRelayAbi.UnpackEvent(out, rlog.Topics, rlog.Data); err != nil {
@@ -192,6 +213,7 @@ func (n *syncer) processBlock(cctx *cli.Context, block *models.BlockEMessage) (f
 						log.Warnf("%s already in order list", out)
 						continue
 					}
+					n._observeTransfers(transferFound, 1)
 					txproofs = append(txproofs, proof)
 					log.Debugf("try to send %d: %s", len(txproofs), proof.InfoString(0))
 				}
@@ -205,16 +227,18 @@ func (n *syncer) processBlock(cctx *cli.Context, block *models.BlockEMessage) (f
 	return nil, nil
 }
 
-func (n *syncer) _txFinalProof(baseCtx context.Context, chainid common.ChainID,
+func (n *syncer) _txFinalProof(ctx context.Context, chainid common.ChainID,
 	txHash common.Hash, anchorHeight common.Height) (*models.TxFinalProof, error) {
-	ctx, cancel := context.WithTimeout(baseCtx, reqTimeOut)
-	defer cancel()
-	resp, err := n.src.NodeClient.GetTxFinalProof(ctx,
-		&tkmrpc.RpcTxProofReq{
-			Chainid:           uint32(chainid),
-			Hash:              txHash[:],
-			ProofedMainHeight: uint64(anchorHeight),
-		})
+	var resp *tkmrpc.RpcResponseStream
+	err := n.src.do(ctx, func(ctx context.Context, c *client.Client) (err error) {
+		resp, err = c.NodeClient.GetTxFinalProof(ctx,
+			&tkmrpc.RpcTxProofReq{
+				Chainid:           uint32(chainid),
+				Hash:              txHash[:],
+				ProofedMainHeight: uint64(anchorHeight),
+			})
+		return err
+	})
 	if err != nil {
 		return nil, fmt.Errorf("TxFinalProof: ChainID:%d TxHash:%x Anchor:%s failed: %w",
 			chainid, txHash[:], &anchorHeight, err)
@@ -226,27 +250,17 @@ func (n *syncer) _txFinalProof(baseCtx context.Context, chainid common.ChainID,
 	return finalProof, nil
 }
 
-func (n *syncer) _lnProof(cctx *cli.Context, txProof *models.TxFinalProof) error {
-	proof, err := T2LN.ReceiptProof(txProof, n.conf.Synchronizer.TkmMCSAddress, n.watchTopicId)
-	if err != nil {
-		return err
-	}
-	log.Infof("proofs: %s", common.IndentLevel(0).InfoString(proof.Proofs))
-	data, err := LightNodeABI.Methods[verifyReceiptStruct].Inputs.Pack(proof)
-	if err != nil {
-		return fmt.Errorf("packdata failed: %w", err)
-	}
+// _lnVerify verifies the packed proof data with a static call to the light node
+func (n *syncer) _lnVerify(ctx context.Context, data []byte) error {
 	input, err := LightNodeABI.Pack(verifyReceiptData, data)
 	if err != nil {
 		return fmt.Errorf("packinput failed: %w", err)
 	}
-	log.Infof("input of verify:\n%x", input)
-	to := n.conf.Synchronizer.TargetMSCAddr
-	output, err := n.target.callContract(cctx.Context, n.targetPriv.Address(), &to, 30000000, nil, nil, input)
+	to := n.conf.Synchronizer.TargetLCAddr
+	output, err := n.target.callContract(ctx, n.targetPriv.Address(), &to, defaultGas, nil, nil, input)
 	if err != nil {
 		return fmt.Errorf("call failed: %w", err)
 	}
-	log.Infof("return: %x", output)
 	retObj := new(struct {
 		Success  bool   `abi:"success"`
 		Mesage   string `abi:"message"`
@@ -258,10 +272,26 @@ func (n *syncer) _lnProof(cctx *cli.Context, txProof *models.TxFinalProof) error
 	if retObj.Success {
 		return nil
 	}
-	return errors.New("verify failed")
+	return fmt.Errorf("verify failed: %s", retObj.Mesage)
 }
 
-func (n *syncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof) error {
+// _preflight verifies the proof data by the TKM light node, and simulates the transferIn with input on
+// the MCS. Any revert returns an error with the decoded reason.
+func (n *syncer) _preflight(ctx context.Context, data, input []byte) error {
+	if err := n._lnVerify(ctx, data); err != nil {
+		return fmt.Errorf("light node: %w", err)
+	}
+	to := n.conf.Synchronizer.TargetMSCAddr
+	if _, err := n.target.callContract(ctx, n.targetPriv.Address(), &to, defaultGas, nil, nil, input); err != nil {
+		if rerr, ok := asRevertError(err); ok {
+			return fmt.Errorf("transferIn: %w", rerr)
+		}
+		return fmt.Errorf("transferIn call failed: %w", err)
+	}
+	return nil
+}
+
+func (n *syncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof) (errr error) {
 	if len(txProofs) == 0 {
 		return nil
 	}
@@ -272,20 +302,23 @@ func (n *syncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof)
 	defer func() {
 		_ = n.sendingLock.Release()
 	}()
+	keeper := keepLease(cctx.Context, n.sendingLock)
+	defer keeper.stop(&errr)
+	cctx = withContext(cctx, keeper.Context())
 
-	dlocks := redisLocks{n.runningLock, n.sendingLock}
-
-	_ = dlocks.Refresh(cctx.Context)
+	dlocks := storeLocks{n.runningLock, n.sendingLock}
 	to := n.conf.Synchronizer.TargetMSCAddr
-	gas, mustHave := n._targetSuggestBalance(cctx.Context)
-	nonce, err := n.target.nonceWithBalanceMoreThan(cctx.Context, n.targetPriv.Address(), n.conf.TargetCheckBalance, mustHave)
-	if err != nil {
-		return err
-	}
 
-	// send txs
-	var ethtxs []*types.Transaction
-	for i, txProof := range txProofs {
+	// estimate all the txs before sending any of them
+	inputs := make([][]byte, 0, len(txProofs))
+	gases := make([]uint64, 0, len(txProofs))
+	refs := make([]string, 0, len(txProofs))
+	var rejects []string // by preflight, block the cursor
+	simFailed := 0       // by dry-run
+	var totalGas uint64
+	for _, txProof := range txProofs {
+		orderId, _ := transferOutOrderId(txProof.Receipt, n.conf.Synchronizer.TkmMCSAddress, n.watchTopicId)
+		ref := fmt.Sprintf("OrderId:%x", orderId[:])
 		proof, err := T2LN.ReceiptProof(txProof, n.conf.Synchronizer.TkmMCSAddress, n.watchTopicId)
 		if err != nil {
 			return err
@@ -299,29 +332,78 @@ func (n *syncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof)
 		if err != nil {
 			return fmt.Errorf("packinput failed: %w", err)
 		}
-		ethtx, _, err := n.target.sendLegacyTx(cctx.Context, n.targetPriv.Priv(), &to, nonce, gas, nil, nil, input)
+		if n.conf.Synchronizer.Preflight {
+			if err := n._preflight(cctx.Context, data, input); err != nil {
+				log.Errorf("preflight of %s failed, never sent: %v", ref, err)
+				rejects = append(rejects, fmt.Sprintf("%s: %v", ref, err))
+				continue
+			}
+		}
+		if n.conf.DryRun {
+			// the cursor of a dry-run moves on, failed simulations are only reported
+			if err := n._simulateTx(cctx.Context, &to, input, ref); err != nil {
+				log.Errorf("%v", err)
+				simFailed++
+			}
+			continue
+		}
+		gas, err := n._targetEstimateGas(cctx.Context, &to, input)
+		if err != nil {
+			return fmt.Errorf("estimate failed: %w", err)
+		}
+		inputs = append(inputs, input)
+		gases = append(gases, gas)
+		refs = append(refs, ref)
+		totalGas += gas
+	}
+	rejectedErr := func() error {
+		if len(rejects) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%d proofs rejected by preflight: [%s]", len(rejects), strings.Join(rejects, "; "))
+	}
+	if len(inputs) == 0 {
+		n._observeTransfers(transferFailed, len(rejects)+simFailed)
+		return rejectedErr()
+	}
+	mustHave := n._targetSuggestBalance(cctx.Context, totalGas)
+	nonce, err := n.target.nonceWithBalanceMoreThan(cctx.Context, n.targetPriv.Address(), n.conf.TargetCheckBalance, mustHave)
+	if err != nil {
+		return err
+	}
+
+	// send txs
+	var ethtxs []*types.Transaction
+	for i, input := range inputs {
+		jctx := putTxJournal(putDistributedLock(cctx.Context, dlocks), n.journal, refs[i])
+		ethtx, _, err := n.target.sendTx(jctx, n.targetPriv.Priv(), &to, nonce, gases[i], nil, input)
 		if err != nil {
 			return fmt.Errorf("send tx failed: %w", err)
 		}
 		ethtxs = append(ethtxs, ethtx)
 		nonce++
-		if i > 0 && i%10 == 0 {
-			_ = dlocks.Refresh(cctx.Context)
-		}
 	}
 
 	// get receipts
-	rcpts, err := n.target.checkReceipts(putDistributedLock(cctx.Context, dlocks), ethtxs...)
+	waitStart := time.Now()
+	defer n._observeReceiptWait(waitStart)
+	rcpts, err := n.target.checkReceipts(putDistributedLock(putTxJournal(cctx.Context, n.journal, ""), dlocks), n.targetPriv.Priv(), ethtxs...)
 	if err != nil {
 		return fmt.Errorf("get receipt failed: %w", err)
 	}
 	var successes, faileds []common.Hash
+	var reasons []string
 	for i, rpt := range rcpts {
+		if rpt != nil {
+			n._observeGasUsed(rpt.GasUsed)
+		}
 		if rpt != nil && rpt.Success() {
 			successes = append(successes, rpt.TxHash)
 		} else {
 			if rpt != nil {
 				faileds = append(faileds, rpt.TxHash)
+				reason := n._failedReason(cctx.Context, ethtxs[i], rpt)
+				reasons = append(reasons, fmt.Sprintf("%x: %s", rpt.TxHash[:], reason))
 			} else {
 				if i < len(ethtxs) && ethtxs[i] != nil {
 					faileds = append(faileds, E2T.Hash(ethtxs[i].Hash()))
@@ -331,14 +413,20 @@ func (n *syncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof)
 			}
 		}
 	}
+	n._observeTransfers(transferRelayed, len(successes))
+	n._observeTransfers(transferFailed, len(faileds)+len(rejects))
 	if len(successes) > 0 {
 		log.Infof("MCS Success: %s", successes)
 	}
 	if len(faileds) > 0 {
 		log.Errorf("MCS failed: %s", faileds)
+		if len(reasons) > 0 {
+			return fmt.Errorf("transfer failed occurs: %d successed, %d failed, reverted: [%s]", len(successes),
+				len(faileds), strings.Join(reasons, "; "))
+		}
 		return fmt.Errorf("transfer failed occurs: %d successed, %d failed", len(successes), len(faileds))
 	}
-	return nil
+	return rejectedErr()
 }
 
 func (n *syncer) _checkOrderId(ctx *cli.Context, orderId common.Hash) (alreadyTransferred bool, err error) {
@@ -368,6 +456,7 @@ func (n *syncer) _maxValidatableHeightFromLC(ctx context.Context) (common.Height
 			return common.NilHeight, fmt.Errorf("UpdatableLC.%s failed: %w", uLastEpochName, err)
 		}
 		epoch := common.EpochNum(outobj.Epoch)
+		n._observeEpoch(epoch)
 		if epoch.IsNil() {
 			return common.NilHeight, cli.Exit(errors.New("unavailable last epoch in LC"), ExitLCErr)
 		}
@@ -379,6 +468,7 @@ func (n *syncer) _maxValidatableHeightFromLC(ctx context.Context) (common.Height
 			return common.NilHeight, fmt.Errorf("LC.%s failed: %w", lastHeightName, err)
 		}
 		height := common.Height(outobj.LastHeight)
+		n._observeHeight(lnHeightGauge, height)
 		if height.IsNil() {
 			return common.NilHeight, cli.Exit(errors.New("unavailable last height in LC"), ExitLCErr)
 		}
@@ -432,6 +522,7 @@ func (n *syncer) _lastConfirmedByMain(ctx context.Context, maxValidatableMainHei
 
 func (n *syncer) _maxProvableHeights(ctx context.Context) (main, sub common.Height, err error) {
 	max, exist := n.maxProvableHeights.Get()
+	n._observeCache(exist && max != nil)
 	if exist && max != nil {
 		return max.main, max.sub, nil
 	}
@@ -0,0 +1,133 @@
+// Copyright 2021 TikBridge
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ThinkiumGroup/go-common"
+	"github.com/ThinkiumGroup/go-tkmrpc/models"
+	common2 "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// stubEth is a target chain whose light node and MCS answer the calls as configured
+type stubEth struct {
+	lc, mcs   common.Address
+	verified  bool
+	reverted  string // revert data of the MCS, not reverted if empty
+	sentCount atomic.Int32
+}
+
+func (s *stubEth) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(big.NewInt(97))
+}
+
+func (s *stubEth) Call(args map[string]interface{}, _ string) (hexutil.Bytes, error) {
+	to, _ := args["to"].(string)
+	switch {
+	case strings.EqualFold(to, common2.Address(s.lc).Hex()):
+		message := ""
+		if !s.verified {
+			message = "invalid proof"
+		}
+		return LightNodeABI.Methods[verifyReceiptData].Outputs.Pack(s.verified, message, []byte{})
+	case strings.EqualFold(to, common2.Address(s.mcs).Hex()):
+		if len(s.reverted) > 0 {
+			return nil, &testRPCError{msg: "execution reverted", data: s.reverted}
+		}
+		return hexutil.Bytes{}, nil
+	}
+	return nil, errors.New("unknown contract")
+}
+
+func (s *stubEth) SendRawTransaction(_ hexutil.Bytes) (common2.Hash, error) {
+	s.sentCount.Add(1)
+	return common2.Hash{}, errors.New("not expected")
+}
+
+func startStubEth(t *testing.T, stub *stubEth) string {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", stub); err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(server)
+	t.Cleanup(func() {
+		hs.Close()
+		server.Stop()
+	})
+	return hs.URL
+}
+
+func TestSyncerPreflight(t *testing.T) {
+	stub := &stubEth{
+		lc:  common.BytesToAddress([]byte{0x01}),
+		mcs: common.BytesToAddress([]byte{0x02}),
+	}
+	ctx := context.Background()
+	target, err := NewEthClient(ctx, []string{startStubEth(t, stub)}, big.NewInt(97), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	priv, _ := hex.DecodeString(testSenderKey)
+	sender, err := models.NewIdentifier(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &syncer{}
+	n.conf = &Config{Synchronizer: Synchronize{TargetLCAddr: stub.lc, TargetMSCAddr: stub.mcs}}
+	n.target, n.targetPriv = target, sender
+	x := &xsyncer{}
+	x.conf = &Config{XSynchronizer: XSynchronize{TargetLCAddr: stub.lc, TargetMSCAddr: stub.mcs}}
+	x.target, x.targetPriv = target, sender
+
+	for _, preflight := range []func(ctx context.Context, data, input []byte) error{n._preflight, x._preflight} {
+		stub.verified, stub.reverted = false, ""
+		if err := preflight(ctx, []byte{0x01}, []byte{0x02}); err == nil ||
+			!strings.Contains(err.Error(), "invalid proof") {
+			t.Fatalf("light node rejection expected, got: %v", err)
+		}
+
+		stub.verified = true
+		// Error("order exists")
+		stub.reverted = "0x08c379a0" +
+			"0000000000000000000000000000000000000000000000000000000000000020" +
+			"000000000000000000000000000000000000000000000000000000000000000c" +
+			"6f72646572206578697374730000000000000000000000000000000000000000"
+		err := preflight(ctx, []byte{0x01}, []byte{0x02})
+		rerr, ok := asRevertError(err)
+		if !ok || rerr.Reason != "order exists" {
+			t.Fatalf("transferIn revert expected, got: %v", err)
+		}
+		t.Log(err)
+
+		stub.reverted = ""
+		if err := preflight(ctx, []byte{0x01}, []byte{0x02}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sent := stub.sentCount.Load(); sent != 0 {
+		t.Fatalf("no tx should be sent by preflight, sent: %d", sent)
+	}
+}
@@ -29,10 +29,8 @@ import (
 	"github.com/ThinkiumGroup/go-common/log"
 	"github.com/ThinkiumGroup/go-common/trie"
 	"github.com/ThinkiumGroup/go-tkmrpc/models"
-	"github.com/bsm/redislock"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/secp256k1"
-	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/sha3"
 )
 
@@ -287,7 +285,7 @@ func (s EthSecp256k1Signer) String() string {
 type redisKeys struct {
 	startHeightKey  string // key of saving start height value
 	runnerLockKey   string // the key of the lock for running one loop
-	runnerLockValue string // locked value IP+"@"+PID
+	runnerLockValue string // locked value IP+"@"+PID, and "#"+name+"@"+source chain of the runner if served with others
 	senderLockKey   string // prefix+sender.Address
 }
 
@@ -300,56 +298,88 @@ type DistributedLock interface {
 	Fetch(cctx context.Context) (lockingValue string, err error)
 	Release() error
 	Refresh(cctx context.Context) error
+	// Validate returns an error if the lock is no longer held by current process, and nothing
+	// should be done under its protection.
+	Validate(cctx context.Context) error
+}
+
+// storeLock is a DistributedLock in the StateStore, value is the token of the holder.
+// Each successful fetch increases the counter at fenceKey, and the new count is the fencing
+// token of the holder. A process stalled past the TTL finds its token stale by Validate, after
+// another process fetched the lock.
+type storeLock struct {
+	store    StateStore
+	key      string
+	fenceKey string
+	value    string
+	ttl      time.Duration
+	held     bool
+	token    uint64
+	lock     sc.Mutex
+	labels   []string // metric label values, nil for not observed
+}
+
+func newStoreLock(store StateStore, key, value string, ttl time.Duration) *storeLock {
+	return &storeLock{
+		store:    store,
+		key:      key,
+		fenceKey: key + fenceKeySuffix,
+		value:    value,
+		ttl:      ttl,
+		held:     false,
+	}
 }
 
-type redisLock struct {
-	redis  *redis.Client
-	locker *redislock.Client
-	key    string
-	value  string
-	ttl    time.Duration
-	rlock  *redislock.Lock
-	lock   sc.Mutex
+func (l *storeLock) String() string {
+	if l == nil {
+		return "StoreLock<nil>"
+	}
+	return fmt.Sprintf("StoreLock{%s}", l.key)
 }
 
-func newRedisLock(client *redis.Client, locker *redislock.Client, key, value string, ttl time.Duration) *redisLock {
-	return &redisLock{
-		redis:  client,
-		locker: locker,
-		key:    key,
-		value:  value,
-		ttl:    ttl,
-		rlock:  nil,
-	}
+// Holding returns whether the lock has been fetched by current process
+func (l *storeLock) Holding() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.held
 }
 
-func (l *redisLock) String() string {
-	if l == nil {
-		return "RedisLock<nil>"
+func (l *storeLock) _failed(op string) {
+	if l.labels != nil {
+		lockFailuresCounter.WithLabelValues(append(append([]string{}, l.labels...), op)...).Inc()
 	}
-	return fmt.Sprintf("RedisLock{%s}", l.key)
 }
 
-func (l *redisLock) _fetch(cctx context.Context) (lockingValue string, err error) {
+func (l *storeLock) _fetch(cctx context.Context) (lockingValue string, err error) {
 	ctx, cancel := context.WithTimeout(cctx, redisTimeout)
 	defer cancel()
-	var lock *redislock.Lock
-	lock, err = l.locker.Obtain(ctx, l.key, l.ttl, &redislock.Options{Token: l.value})
-	if err != nil {
-		if err == redislock.ErrNotObtained {
-			lockingValue = l.redis.Get(ctx, l.key).Val()
+	defer func() {
+		if err != nil {
+			l._failed("fetch")
 		}
-	} else {
-		l.rlock = lock
+	}()
+	lockingValue, err = l.store.ObtainLock(ctx, l.key, l.value, l.ttl)
+	if err != nil {
+		return "", err
+	}
+	if lockingValue != l.value {
+		return lockingValue, errors.New("lock not obtained")
 	}
-	return
+	token, err := l.store.Incr(ctx, l.fenceKey)
+	if err != nil {
+		_ = l.store.ReleaseLock(ctx, l.key, l.value)
+		return "", fmt.Errorf("fencing token failed: %w", err)
+	}
+	l.held = true
+	l.token = token
+	return lockingValue, nil
 }
 
-func (l *redisLock) Fetch(cctx context.Context) (lockingValue string, err error) {
+func (l *storeLock) Fetch(cctx context.Context) (lockingValue string, err error) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	if l.rlock != nil {
-		return l.rlock.Token(), errors.New("lock already fetched")
+	if l.held {
+		return l.value, errors.New("lock already fetched")
 	}
 	defer func() {
 		if err == nil {
@@ -359,7 +389,7 @@ func (l *redisLock) Fetch(cctx context.Context) (lockingValue string, err error)
 	return l._fetch(cctx)
 }
 
-func (l *redisLock) Release() (err error) {
+func (l *storeLock) Release() (err error) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	defer func() {
@@ -369,23 +399,27 @@ func (l *redisLock) Release() (err error) {
 			log.Debugf("%s released", l)
 		}
 	}()
-	if l.rlock == nil {
+	if !l.held {
 		return nil
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
 	defer cancel()
-	err = l.rlock.Release(ctx)
-	l.rlock = nil
+	err = l.store.ReleaseLock(ctx, l.key, l.value)
+	l.held = false
 	return err
 }
 
-func (l *redisLock) _refresh(cctx context.Context) error {
+func (l *storeLock) _refresh(cctx context.Context) error {
 	ctx, cancel := context.WithTimeout(cctx, redisTimeout)
 	defer cancel()
-	return l.rlock.Refresh(ctx, l.ttl, nil)
+	err := l.store.RefreshLock(ctx, l.key, l.value, l.ttl)
+	if err != nil {
+		l._failed("refresh")
+	}
+	return err
 }
 
-func (l *redisLock) Refresh(cctx context.Context) (err error) {
+func (l *storeLock) Refresh(cctx context.Context) (err error) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 	defer func() {
@@ -395,17 +429,17 @@ func (l *redisLock) Refresh(cctx context.Context) (err error) {
 			log.Debugf("%s refreshed", l)
 		}
 	}()
-	if l.rlock == nil {
+	if !l.held {
 		return errors.New("lock not fetched")
 	}
 	return l._refresh(cctx)
 }
 
-func (l *redisLock) FetchOrRefresh(cctx context.Context) (lockingValue string, err error) {
+func (l *storeLock) FetchOrRefresh(cctx context.Context) (lockingValue string, err error) {
 	l.lock.Lock()
 	defer l.lock.Unlock()
 
-	if l.rlock == nil {
+	if !l.held {
 		return l._fetch(cctx)
 	} else {
 		err = l._refresh(cctx)
@@ -416,17 +450,70 @@ func (l *redisLock) FetchOrRefresh(cctx context.Context) (lockingValue string, e
 	}
 }
 
-type redisLocks []*redisLock
+// Token returns the fencing token of the lock, 0 if not held
+func (l *storeLock) Token() uint64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.held {
+		return 0
+	}
+	return l.token
+}
+
+func (l *storeLock) Validate(cctx context.Context) (err error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	defer func() {
+		if err != nil {
+			l._failed("validate")
+		}
+	}()
+	if !l.held {
+		return errors.New("lock not fetched")
+	}
+	ctx, cancel := context.WithTimeout(cctx, redisTimeout)
+	defer cancel()
+	holder, err := l.store.LockHolder(ctx, l.key)
+	if err != nil {
+		return err
+	}
+	if holder != l.value {
+		return fmt.Errorf("%s held by [%s]: %w", l, holder, ErrLockNotHeld)
+	}
+	current, _, err := l.store.Cursor(ctx, l.fenceKey)
+	if err != nil {
+		return err
+	}
+	if current != l.token {
+		return fmt.Errorf("%s token:%d current:%d: %w", l, l.token, current, ErrStaleToken)
+	}
+	return nil
+}
+
+type storeLocks []*storeLock
 
-func (r redisLocks) Fetch(_ context.Context) (string, error) {
+func (r storeLocks) Fetch(_ context.Context) (string, error) {
 	return "", common.ErrUnsupported
 }
 
-func (r redisLocks) Release() error {
+func (r storeLocks) Release() error {
 	return common.ErrUnsupported
 }
 
-func (r redisLocks) Refresh(cctx context.Context) error {
+// Validate validates all the held locks
+func (r storeLocks) Validate(cctx context.Context) error {
+	for _, l := range r {
+		if l == nil || !l.Holding() {
+			continue
+		}
+		if err := l.Validate(cctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r storeLocks) Refresh(cctx context.Context) error {
 	var errs []error
 	for _, l := range r {
 		if l == nil {
@@ -476,6 +563,17 @@ func (e *Expirable[T]) Update(val T) {
 	e.expires = n.UnixMilli() + e.ttlms
 }
 
+// SetTTL changes the TTL of the following updates, and shortens the current one if it would
+// expire later than the new TTL.
+func (e *Expirable[T]) SetTTL(ttlms int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.ttlms = ttlms
+	if limit := time.Now().UnixMilli() + ttlms; e.expires > limit {
+		e.expires = limit
+	}
+}
+
 type LockError interface {
 	error
 	Unlock() bool
@@ -60,3 +60,17 @@ func TestExpirable(t *testing.T) {
 		t.Fatalf("set not-nil failed: v=%s exist=%t", v, exist)
 	}
 }
+
+func TestExpirableSetTTL(t *testing.T) {
+	e := NewExpirable[int](0, 60000, 0)
+	e.Update(1)
+	e.SetTTL(0)
+	if _, exist := e.Get(); exist {
+		t.Fatal("value should expire with the shorter TTL")
+	}
+	e.SetTTL(60000)
+	e.Update(2)
+	if v, exist := e.Get(); !exist || v != 2 {
+		t.Fatalf("v=%d exist=%t", v, exist)
+	}
+}
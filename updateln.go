@@ -15,6 +15,8 @@
 package main
 
 import (
+	sc "sync"
+
 	"github.com/ThinkiumGroup/go-common/abi"
 )
 
@@ -836,6 +838,10 @@ const (
 	uUpdateCommEvent    = "UpdateCommittee"
 )
 
+var updatableLNAbiOnce sc.Once
+
 func initUpdatableLNAbi() {
-	UpdatableLightNodeAbi = *abi.MustInitAbi("updatable light node", tkmUpdateLcString)
+	updatableLNAbiOnce.Do(func() {
+		UpdatableLightNodeAbi = *abi.MustInitAbi("updatable light node", tkmUpdateLcString)
+	})
 }
@@ -18,14 +18,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/log"
 	"github.com/ThinkiumGroup/go-common/math"
 	"github.com/ThinkiumGroup/go-tkmrpc/models"
-	"github.com/redis/go-redis/v9"
 	"github.com/stephenfire/go-rtl"
 	"github.com/urfave/cli/v2"
 )
@@ -50,7 +49,9 @@ func (u *updater) _lastEpochInLC(cctx context.Context) (common.EpochNum, error)
 		UpdatableLightNodeAbi.Methods[uLastEpochName], outobj); err != nil {
 		return common.NilEpoch, fmt.Errorf("updatable lightnode.%s failed: %w", uLastEpochName, err)
 	}
-	return common.EpochNum(outobj.Epoch), nil
+	epoch := common.EpochNum(outobj.Epoch)
+	u._observeEpoch(epoch)
+	return epoch, nil
 }
 
 func (u *updater) _lastCommitteeInLC(cctx context.Context) ([]common.Address, error) {
@@ -68,21 +69,21 @@ func (u *updater) _lastCommitteeInLC(cctx context.Context) ([]common.Address, er
 func (u *updater) _lastUpdateTimeInCache(ctx context.Context) (int64, error) {
 	cctx, cancel := context.WithTimeout(ctx, redisTimeout)
 	defer cancel()
-	l, err := u.redis.Get(cctx, u.lastUpdateTimeKey).Int64()
+	value, exist, err := u.store.Get(cctx, u.lastUpdateTimeKey)
 	switch {
-	case err == redis.Nil:
-		return 0, nil
 	case err != nil:
 		return 0, err
+	case !exist:
+		return 0, nil
 	default:
-		return l, nil
+		return strconv.ParseInt(value, 10, 64)
 	}
 }
 
 func (u *updater) _updateToLastUpdateTimeInCache(ctx context.Context, newValue int64) (int64, error) {
 	cctx, cancel := context.WithTimeout(ctx, redisTimeout)
 	defer cancel()
-	if err := u.redis.Set(cctx, u.lastUpdateTimeKey, fmt.Sprintf("%d", newValue), 0).Err(); err != nil {
+	if err := u.store.Set(cctx, u.lastUpdateTimeKey, strconv.FormatInt(newValue, 10)); err != nil {
 		return 0, err
 	}
 	return newValue, nil
@@ -101,8 +102,8 @@ func (u *updater) prepareConfig(ctx *cli.Context) error {
 	if ctx.Uint64(_updaterPostponeFlag.Name) > 0 {
 		u.needs = u.needs.Clear(NeedSource, NeedTarget, NeedRunningLock)
 	}
-	u.keys.runnerLockKey = fmt.Sprintf("%s_lock_%d", strings.ToLower(u.Name()), u.conf.SrcChainId)
-	u.lastUpdateTimeKey = fmt.Sprintf("%s_lastTimeStamp_%d", strings.ToLower(u.Name()), u.conf.SrcChainId)
+	u.keys.runnerLockKey = u.keyBuilder().runnerLock(u.Name(), u.conf.SrcChainId)
+	u.lastUpdateTimeKey = u.keyBuilder().lastUpdateTime(u.Name(), u.conf.SrcChainId)
 	log.Infof("%s, lastUpdateKey: %s", u.keys, u.lastUpdateTimeKey)
 
 	if _, exist := UpdatableLightNodeAbi.Events[uUpdateCommEvent]; !exist {
@@ -201,22 +202,39 @@ func (u *updater) doWork(ctx *cli.Context) error {
 		defer func() {
 			_ = u.runningLock.Release()
 		}()
-		return u._once(ctx)
+		return u._underLease(ctx, u._once, u.runningLock)
 	default:
 		awake := time.Second * u.getFetchInterval()
 		timer := time.NewTimer(awake)
 		defer timer.Stop()
+		reloads, unsubscribe := configReloads.subscribe()
+		defer unsubscribe()
 		for {
 			select {
+			case <-reloads:
+				if err := u.reload(ctx); err != nil {
+					log.Errorf("%s reload failed: %v", u, err)
+				}
+				awake = time.Second * u.getFetchInterval()
 			case <-timer.C:
-				if err := u.connectionCheck(); err != nil {
-					return err
+				if err := u.connectionCheck(ctx); err != nil {
+					var exitErr cli.ExitCoder
+					if errors.As(err, &exitErr) {
+						return err
+					}
+					// release the locks so that other processes can take over
+					log.Errorf("connection check failed and release locks: %v", err)
+					_ = u.runningLock.Release()
+					_ = u.sendingLock.Release()
+					timer.Reset(awake)
+					continue
 				}
+				u._observeBalance(ctx.Context)
 				value, err := u.runningLock.FetchOrRefresh(ctx.Context)
 				if err != nil {
 					log.Debugf("[%s] is running, fetch-refresh %s failed: %v", value, u.runningLock, err)
 				} else {
-					if err := u._once(ctx); err != nil {
+					if err := u._underLease(ctx, u._once, u.runningLock); err != nil {
 						switch err.(type) {
 						case cli.ExitCoder:
 							return err
@@ -287,7 +305,7 @@ func (u *updater) _parseULNUpdateEvent(l *models.Log) (*updateEvent, error) {
 	}, nil
 }
 
-func (u *updater) _updateCommittee(ctx context.Context, epoch common.EpochNum, comm *models.Committee) error {
+func (u *updater) _updateCommittee(ctx context.Context, epoch common.EpochNum, comm *models.Committee) (errr error) {
 	lockingValue, err := u.sendingLock.Fetch(ctx)
 	if err != nil {
 		return fmt.Errorf("[%s] is sending, fetch %s failed: %w", lockingValue, u.sendingLock, err)
@@ -295,31 +313,45 @@ func (u *updater) _updateCommittee(ctx context.Context, epoch common.EpochNum, c
 	defer func() {
 		_ = u.sendingLock.Release()
 	}()
-	gas, mustHave := u._targetSuggestBalance(ctx)
-	nonce, err := u.target.nonceWithBalanceMoreThan(ctx, u.targetPriv.Address(), u.conf.TargetCheckBalance, mustHave)
-	if err != nil {
-		return fmt.Errorf("get nonce of %x failed: %w", u.targetPriv.Address().Bytes(), err)
-	}
+	keeper := keepLease(ctx, u.sendingLock)
+	defer keeper.stop(&errr)
+	ctx = keeper.Context()
 	input, err := UpdatableLightNodeAbi.Pack(uUpdateCommName, uint64(epoch), common.NodeIDs(comm.Members).ToBytesSlice())
 	if err != nil {
 		return fmt.Errorf("packinput failed: %w", err)
 	}
 	to := u.conf.Updater.TargetLCAddr
+	if u.conf.DryRun {
+		return u._simulateTx(ctx, &to, input, fmt.Sprintf("Epoch:%d", epoch))
+	}
+	gas, err := u._targetEstimateGas(ctx, &to, input)
+	if err != nil {
+		return fmt.Errorf("estimate failed: %w", err)
+	}
+	mustHave := u._targetSuggestBalance(ctx, gas)
+	nonce, err := u.target.nonceWithBalanceMoreThan(ctx, u.targetPriv.Address(), u.conf.TargetCheckBalance, mustHave)
+	if err != nil {
+		return fmt.Errorf("get nonce of %x failed: %w", u.targetPriv.Address().Bytes(), err)
+	}
 
-	ethtx, txhash, err := u.target.sendLegacyTx(ctx, u.targetPriv.Priv(), &to, nonce, gas, nil, nil, input)
+	jctx := putTxJournal(putDistributedLock(ctx, storeLocks{u.runningLock, u.sendingLock}), u.journal, fmt.Sprintf("Epoch:%d", epoch))
+	ethtx, txhash, err := u.target.sendTx(jctx, u.targetPriv.Priv(), &to, nonce, gas, nil, input)
 	if err != nil {
 		return fmt.Errorf("send tx failed: %w", err)
 	}
 
 	log.Infof("update comm TxHash: %x", common.ForPrint(txhash, 0))
 
-	rcpt, err := u.target.checkReceipt(putDistributedLock(ctx, redisLocks{u.runningLock, u.sendingLock}), ethtx)
+	waitStart := time.Now()
+	rcpt, err := u.target.checkReceipt(jctx, u.targetPriv.Priv(), ethtx)
 	if err != nil {
 		return fmt.Errorf("get receipt failed: %w", err)
 	}
+	u._observeReceiptWait(waitStart)
+	u._observeGasUsed(rcpt.GasUsed)
 	log.Debugf("%s", rcpt.InfoString(0))
 	if !rcpt.Success() {
-		return fmt.Errorf("tx failed: %w", rcpt.Err())
+		return fmt.Errorf("tx %x failed: %s", rcpt.TxHash[:], u._failedReason(ctx, ethtx, rcpt))
 	}
 	updateEvent := UpdatableLightNodeAbi.Events[uUpdateCommEvent]
 	for _, l := range rcpt.Logs {
@@ -25,7 +25,6 @@ import (
 	"time"
 
 	"github.com/ThinkiumGroup/go-common"
-	"github.com/ThinkiumGroup/go-tkmrpc/client"
 	"github.com/ThinkiumGroup/go-tkmrpc/models"
 	"golang.org/x/term"
 )
@@ -55,7 +54,7 @@ func unixSecondsString(us int64) string {
 	return time.Unix(us, 0).Format(timeFormat)
 }
 
-func getSourceCommOfEpoch(ctx context.Context, src *client.Client, epoch common.EpochNum) (*models.Committee, error) {
+func getSourceCommOfEpoch(ctx context.Context, src *sourcePool, epoch common.EpochNum) (*models.Committee, error) {
 	nids, err := src.Committee(ctx, epoch)
 	if err != nil {
 		return nil, fmt.Errorf("get committee of Epoch:%s failed: %w", epoch, err)
@@ -150,6 +149,23 @@ func locateLog(logs models.Logs, contractAddr common.Address, topicId common.Has
 	return -1, nil
 }
 
+// transferOutOrderId returns the orderId of the TransferOut event emitted by contract mcs in
+// receipt, if there is one.
+func transferOutOrderId(receipt *models.Receipt, mcs common.Address, topicId common.Hash) (common.Hash, bool) {
+	if receipt == nil {
+		return common.Hash{}, false
+	}
+	_, rlog := locateLog(receipt.Logs, mcs, topicId)
+	if rlog == nil {
+		return common.Hash{}, false
+	}
+	out := new(MapTransferOutLog)
+	if err := MCSRelayAbi.UnpackEvent(out, rlog.Topics, rlog.Data); err != nil {
+		return common.Hash{}, false
+	}
+	return out.OrderId, true
+}
+
 func shouldIgnoreHeight(height common.Height) (nextStart common.Height, ignored bool) {
 	if height.IsNil() {
 		return 0, true
@@ -16,6 +16,7 @@ package main
 
 import (
 	"fmt"
+	sc "sync"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/abi"
@@ -833,8 +834,12 @@ const (
 	xCheckEpochCommName = "checkEpochCommittee"
 )
 
+var relayLNAbiOnce sc.Once
+
 func initRelayLNAbi() {
-	XLightNodeAbi = *abi.MustInitAbi("relay chain light node", xLightNodeString)
+	relayLNAbiOnce.Do(func() {
+		XLightNodeAbi = *abi.MustInitAbi("relay chain light node", xLightNodeString)
+	})
 }
 
 type XCommProof struct {
@@ -19,7 +19,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
-	"strings"
+	"time"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/log"
@@ -48,8 +48,8 @@ func (a *xmaintainer) prepareConfig(ctx *cli.Context) error {
 	if err := a.looper.prepareConfig(ctx); err != nil {
 		return err
 	}
-	a.keys.startHeightKey = fmt.Sprintf("%s_start_%d", strings.ToLower(a.Name()), a.conf.SrcChainId)
-	a.keys.runnerLockKey = fmt.Sprintf("%s_lock_%d", strings.ToLower(a.Name()), a.conf.SrcChainId)
+	a.keys.startHeightKey = a.keyBuilder().startHeight(a.Name(), a.conf.SrcChainId)
+	a.keys.runnerLockKey = a.keyBuilder().runnerLock(a.Name(), a.conf.SrcChainId)
 
 	if _, exist := XLightNodeAbi.Events[xUpdateCommEvent]; !exist {
 		return fmt.Errorf("event %s must be exist", xUpdateCommEvent)
@@ -65,48 +65,61 @@ func (a *xmaintainer) prepareConfig(ctx *cli.Context) error {
 		return err
 	}
 	models.SysContractLogger.Register(a.conf.Maintainer.TargetLCAddr, LightNodeABI)
-	a.syncStartHeightKey = a.conf.XMaintainer.XsyncStartHeightKey
+	a.syncStartHeightKey = a.keyBuilder().key(a.conf.XMaintainer.XsyncStartHeightKey)
 	log.Infof("%s, SyncStartHeightKey: %s", a.keys, a.syncStartHeightKey)
 	return nil
 }
 
-func (a *xmaintainer) confirmConfig(ctx *cli.Context) error {
-	if err := a.looper.confirmConfig(ctx); err != nil {
-		return err
-	}
-
-	// check availability of light-node and get lastHeight
-	lastHeight := common.NilHeight
+// _lastHeight returns the lastHeight of the X-light-node
+func (a *xmaintainer) _lastHeight(ctx context.Context) (common.Height, error) {
 	input, err := XLightNodeAbi.Pack(xLastHeightName)
 	if err != nil {
-		return fmt.Errorf("encode xlightnode.lastHeight() failed: %w", err)
+		return common.NilHeight, fmt.Errorf("encode xlightnode.lastHeight() failed: %w", err)
 	}
-	output, err := a.target.callContract(ctx.Context, a.targetPriv.Address(), &a.conf.XMaintainer.TargetLCAddr, defaultGas, nil, nil, input)
+	output, err := a.target.callContract(ctx, a.targetPriv.Address(), &a.conf.XMaintainer.TargetLCAddr, defaultGas, nil, nil, input)
 	if err != nil {
-		return fmt.Errorf("xlightnode.lastHeight() failed: %w", err)
+		return common.NilHeight, fmt.Errorf("xlightnode.lastHeight() failed: %w", err)
 	}
 	outobj := new(struct {
 		Height uint64
 	})
 	if err = XLightNodeAbi.UnpackReturns(outobj, xLastHeightName, output); err != nil {
-		return fmt.Errorf("parse lastHeight failed: %w", err)
+		return common.NilHeight, fmt.Errorf("parse lastHeight failed: %w", err)
 	}
-	lastHeight = common.Height(outobj.Height)
+	lastHeight := common.Height(outobj.Height)
 	log.Infof("lastHeight of X-light-node: %s", &lastHeight)
+	a._observeHeight(lnHeightGauge, lastHeight)
+	return lastHeight, nil
+}
+
+func (a *xmaintainer) confirmConfig(ctx *cli.Context) error {
+	if err := a.looper.confirmConfig(ctx); err != nil {
+		return err
+	}
+
+	// check availability of light-node and get lastHeight
+	lastHeight, err := a._lastHeight(ctx.Context)
+	if err != nil {
+		return err
+	}
 
 	// update lastHeight
-	newHeight := lastHeight + 1
+	minStart, maxStart := cursorRange(lastHeight)
 	startHeight := a.getStartHeight(ctx)
-	diff, cmp := startHeight.Diff(newHeight)
-	if cmp < 0 {
-		log.Warnf("replace start height from:%s to X-light-node.lastHeight+1: %s", &startHeight, &newHeight)
-		if err := a.updateStartHeight(ctx, newHeight); err != nil {
-			return fmt.Errorf("update start height (%d) failed: %w", newHeight, err)
+	if startHeight.Compare(minStart) < 0 {
+		log.Warnf("replace start height from:%s to X-light-node.lastHeight+1: %s", &startHeight, &minStart)
+		if err := a.updateStartHeight(ctx, minStart); err != nil {
+			return fmt.Errorf("update start height (%d) failed: %w", minStart, err)
 		}
-	} else if cmp > 0 {
-		if diff >= common.BlocksInEpoch {
+	} else if startHeight.Compare(maxStart) > 0 {
+		if !a.conf.DryRun {
 			return fmt.Errorf("startHeight:%s but X-light-node.lastHeight:%s", &startHeight, &lastHeight)
 		}
+		// the light node is never updated by a dry-run
+		log.Warnf("DRY-RUN: rewind start height from:%s to X-light-node.lastHeight+1: %s", &startHeight, &minStart)
+		if err := a.updateStartHeight(ctx, minStart); err != nil {
+			return fmt.Errorf("update start height (%d) failed: %w", minStart, err)
+		}
 	}
 	log.Infof("start height: %d", a.getStartHeight(ctx))
 
@@ -146,10 +159,12 @@ func (a *xmaintainer) prepareToGet(cctx *cli.Context, start common.Height) error
 func (a *xmaintainer) _getSyncingEpoch(cctx *cli.Context) (common.EpochNum, error) {
 	ctx, cancel := context.WithTimeout(cctx.Context, redisTimeout)
 	defer cancel()
-	h, err := a.redis.Get(ctx, a.syncStartHeightKey).Uint64()
+	h, exist, err := a.store.Cursor(ctx, a.syncStartHeightKey)
 	switch {
 	case err != nil:
 		return 0, err
+	case !exist:
+		return 0, fmt.Errorf("%s not found", a.syncStartHeightKey)
 	default:
 		return common.Height(h).EpochNum(), nil
 	}
@@ -204,7 +219,7 @@ func (a *xmaintainer) processBlock(cctx *cli.Context, block *models.BlockEMessag
 	return nil, nil
 }
 
-func (a *xmaintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof) error {
+func (a *xmaintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof) (errr error) {
 	if err := comm.Verify(false); err != nil {
 		return err
 	}
@@ -216,6 +231,9 @@ func (a *xmaintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof)
 	defer func() {
 		_ = a.sendingLock.Release()
 	}()
+	keeper := keepLease(cctx.Context, a.sendingLock)
+	defer keeper.stop(&errr)
+	cctx = withContext(cctx, keeper.Context())
 
 	// proof := comm.ForXABI()
 	proof, err := comm.ForXDataABI()
@@ -228,30 +246,37 @@ func (a *xmaintainer) _targetUpdateComm(cctx *cli.Context, comm *CommitteeProof)
 		return fmt.Errorf("packinput failed: %w", err)
 	}
 	to := a.conf.XMaintainer.TargetLCAddr
+	if a.conf.DryRun {
+		return a._simulateTx(cctx.Context, &to, input, fmt.Sprintf("Epoch:%d", comm.Header.Height.EpochNum()+1))
+	}
 
-	// gas, err := a.target.estimateGas(id.Address(), &to, 30000000, nil, nil, input)
-	// if err != nil {
-	// 	return fmt.Errorf("estimate failed: %w", err)
-	// }
-	gas, mustHave := a._targetSuggestBalance(cctx.Context)
+	gas, err := a._targetEstimateGas(cctx.Context, &to, input)
+	if err != nil {
+		return fmt.Errorf("estimate failed: %w", err)
+	}
+	mustHave := a._targetSuggestBalance(cctx.Context, gas)
 	nonce, err := a.target.nonceWithBalanceMoreThan(cctx.Context, a.targetPriv.Address(), a.conf.TargetCheckBalance, mustHave)
 	if err != nil {
 		return err
 	}
 
-	ethtx, txhash, err := a.target.sendLegacyTx(cctx.Context, a.targetPriv.Priv(), &to, nonce, gas, nil, nil, input)
+	jctx := putTxJournal(putDistributedLock(cctx.Context, storeLocks{a.runningLock, a.sendingLock}), a.journal, fmt.Sprintf("Epoch:%d", comm.Header.Height.EpochNum()+1))
+	ethtx, txhash, err := a.target.sendTx(jctx, a.targetPriv.Priv(), &to, nonce, gas, nil, input)
 	if err != nil {
 		return fmt.Errorf("send tx failed: %w", err)
 	}
 
 	log.Infof("update comm TxHash: %x", common.ForPrint(txhash, 0))
-	rcpt, err := a.target.checkReceipt(putDistributedLock(cctx.Context, redisLocks{a.runningLock, a.sendingLock}), ethtx)
+	waitStart := time.Now()
+	rcpt, err := a.target.checkReceipt(jctx, a.targetPriv.Priv(), ethtx)
 	if err != nil {
 		return fmt.Errorf("get receipt failed: %w", err)
 	}
+	a._observeReceiptWait(waitStart)
+	a._observeGasUsed(rcpt.GasUsed)
 	log.Debugf("%s", rcpt.InfoString(0))
 	if !rcpt.Success() {
-		return fmt.Errorf("tx failed: %w", rcpt.Err())
+		return fmt.Errorf("tx %x failed: %s", rcpt.TxHash[:], a._failedReason(cctx.Context, ethtx, rcpt))
 	}
 	updateEvent := XLightNodeAbi.Events[xUpdateCommEvent]
 	for _, l := range rcpt.Logs {
@@ -20,11 +20,13 @@ import (
 	"fmt"
 	"math/big"
 	"strings"
+	"time"
 
 	"github.com/ThinkiumGroup/go-common"
 	"github.com/ThinkiumGroup/go-common/log"
 	"github.com/ThinkiumGroup/go-common/math"
 	"github.com/ThinkiumGroup/go-tkmrpc"
+	"github.com/ThinkiumGroup/go-tkmrpc/client"
 	"github.com/ThinkiumGroup/go-tkmrpc/models"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/stephenfire/go-rtl"
@@ -35,6 +37,7 @@ type xsyncer struct {
 	looper
 	watchTopicId      common.Hash
 	maxProvableHeight *Expirable[*common.Height]
+	filter            *txFilter
 }
 
 func (n *xsyncer) Name() string {
@@ -47,8 +50,8 @@ func (n *xsyncer) prepareConfig(ctx *cli.Context) error {
 	if err := n.looper.prepareConfig(ctx); err != nil {
 		return err
 	}
-	n.keys.startHeightKey = fmt.Sprintf("%s_start_%d", strings.ToLower(n.Name()), n.conf.SrcChainId)
-	n.keys.runnerLockKey = fmt.Sprintf("%s_lock_%d", strings.ToLower(n.Name()), n.conf.SrcChainId)
+	n.keys.startHeightKey = n.keyBuilder().startHeight(n.Name(), n.conf.SrcChainId)
+	n.keys.runnerLockKey = n.keyBuilder().runnerLock(n.Name(), n.conf.SrcChainId)
 	log.Infof("%s", n.keys)
 
 	xMcs, err := stringToAddress(ctx, _xSyncMCSFlag.Name)
@@ -68,6 +71,12 @@ func (n *xsyncer) prepareConfig(ctx *cli.Context) error {
 	n.conf.XSynchronizer.XMCSAddress = xMcs
 	n.conf.XSynchronizer.TargetLCAddr = targetlc
 	n.conf.XSynchronizer.MaxHeightTTL = int64(ctx.Uint64(_xSyncMaxHeightTTLFlag.Name))
+	n.conf.XSynchronizer.Preflight = ctx.Bool(_xSyncPreflightFlag.Name)
+	routers, err := stringToAddresses(ctx, _xSyncRoutersFlag.Name)
+	if err != nil {
+		return err
+	}
+	n.conf.XSynchronizer.Routers = routers
 
 	if err := n.conf.XSynchronizer.validate(); err != nil {
 		return err
@@ -86,6 +95,10 @@ func (n *xsyncer) prepareConfig(ctx *cli.Context) error {
 	}
 	n.watchTopicId = event.ID
 	log.Infof("watching: Address:%x EventTopic:%x", n.conf.XSynchronizer.XMCSAddress[:], n.watchTopicId[:])
+	n.filter, err = newTxFilter(n.conf.SrcPrefilter, n.conf.XSynchronizer.XMCSAddress, n.watchTopicId, n.conf.XSynchronizer.Routers)
+	if err != nil {
+		return cli.Exit(err, ExitByConfig)
+	}
 	return nil
 }
 
@@ -108,9 +121,7 @@ func (n *xsyncer) confirmConfig(ctx *cli.Context) error {
 }
 
 func (n *xsyncer) _targetMustContract(cctx *cli.Context, addr common.Address) bool {
-	ctx, cancel := context.WithCancel(cctx.Context)
-	defer cancel()
-	code, err := n.target.Client.CodeAt(ctx, T2E.Address(addr), nil)
+	code, err := n.target.getCode(cctx.Context, addr)
 	if err != nil {
 		return false
 	}
@@ -127,6 +138,7 @@ func (n *xsyncer) _maxValidatableHeightFromLC(ctx context.Context) (common.Heigh
 		return common.NilHeight, fmt.Errorf("getter XLC.%s failed: %w", xEndsOfEpochName, err)
 	}
 	lastEpoch := common.EpochNum(outobj.LastEpoch)
+	n._observeEpoch(lastEpoch)
 	if lastEpoch.IsNil() {
 		return common.NilHeight, cli.Exit(errors.New("unavailable last epoch in LC"), ExitLCErr)
 	}
@@ -135,6 +147,7 @@ func (n *xsyncer) _maxValidatableHeightFromLC(ctx context.Context) (common.Heigh
 
 func (n *xsyncer) _maxProvableHeight(ctx context.Context) (common.Height, error) {
 	max, exist := n.maxProvableHeight.Get()
+	n._observeCache(exist && max != nil)
 	if exist && max != nil {
 		return *max, nil
 	}
@@ -170,25 +183,36 @@ func (n *xsyncer) processBlock(cctx *cli.Context, block *models.BlockEMessage) (
 			return NotUnlockError(fmt.Errorf("max provable height exceeded: Max:%s, but Block.Height:%s",
 				&max, &block.BlockHeader.Height)), nil
 		}
-		var txproofs []*models.TxFinalProof
-		for _, tx := range block.BlockBody.Txs {
-			if tx.To != nil && len(tx.Input) > 0 {
-				_ = n.runningLock.Refresh(cctx.Context)
+		candidates, err := n._prefilter(cctx.Context, n.filter, block)
+		if err != nil {
+			return err, nil
+		}
+		proofs, err := fetchOrdered(cctx.Context, n.conf.SrcConcurrency, len(candidates),
+			func(ctx context.Context, i int) (*models.TxFinalProof, error) {
+				tx := candidates[i]
 				txHash := tx.Hash()
-				proof, err := n._txLocalProof(cctx.Context, n.conf.SrcChainId, txHash)
+				proof, err := n._txLocalProof(ctx, n.conf.SrcChainId, txHash)
 				if err != nil || proof == nil {
-					return fmt.Errorf("get local proof of TxHash:%x failed: %w", txHash[:], err), nil
+					return nil, fmt.Errorf("get local proof of TxHash:%x failed: %w", txHash[:], err)
+				}
+				if proof.Receipt == nil {
+					return nil, fmt.Errorf("get receipt of TxHash:%x failed", txHash[:])
 				}
 				if !proof.Receipt.Success() {
 					log.Debugf("%s failed", tx)
-					continue
+					return nil, nil
 				}
 				if err := proof.LocalVerify(); err != nil {
-					return fmt.Errorf("local proof %s verify failed: %w", proof, err), nil
-				}
-				if proof.Receipt == nil {
-					return fmt.Errorf("get receipt of TxHash:%x failed", txHash[:]), nil
+					return nil, fmt.Errorf("local proof %s verify failed: %w", proof, err)
 				}
+				return proof, nil
+			})
+		if err != nil {
+			return err, nil
+		}
+		var txproofs []*models.TxFinalProof
+		for _, proof := range proofs {
+			if proof != nil {
 				if i, rlog := locateLog(proof.Receipt.Logs, n.conf.XSynchronizer.XMCSAddress, n.watchTopicId); i >= 0 {
 					out := new(MapTransferOutLog)
 					if err := MCSRelayAbi.UnpackEvent(out, rlog.Topics, rlog.Data); err != nil {
@@ -207,13 +231,13 @@ func (n *xsyncer) processBlock(cctx *cli.Context, block *models.BlockEMessage) (
 						log.Warnf("%s already in order list", out)
 						continue
 					}
+					n._observeTransfers(transferFound, 1)
 					txproofs = append(txproofs, proof)
 					log.Debugf("try to send %d: %s", len(txproofs), proof.InfoString(0))
 				}
 			}
 		}
 
-		// if err := n._lnProofs(cctx, txproofs...); err != nil {
 		if err := n._mcsProofs(cctx, txproofs); err != nil {
 			return fmt.Errorf("MCS proof failed: %w", err), nil
 		}
@@ -221,12 +245,14 @@ func (n *xsyncer) processBlock(cctx *cli.Context, block *models.BlockEMessage) (
 	return nil, nil
 }
 
-func (n *xsyncer) _txLocalProof(baseCtx context.Context, chainid common.ChainID, txHash common.Hash) (*models.TxFinalProof, error) {
-	ctx, cancel := context.WithTimeout(baseCtx, reqTimeOut)
-	defer cancel()
-	resp, err := n.src.NodeClient.GetTxLocalProof(ctx, &tkmrpc.RpcTXHash{
-		Chainid: uint32(chainid),
-		Hash:    txHash[:],
+func (n *xsyncer) _txLocalProof(ctx context.Context, chainid common.ChainID, txHash common.Hash) (*models.TxFinalProof, error) {
+	var resp *tkmrpc.RpcResponseStream
+	err := n.src.do(ctx, func(ctx context.Context, c *client.Client) (err error) {
+		resp, err = c.NodeClient.GetTxLocalProof(ctx, &tkmrpc.RpcTXHash{
+			Chainid: uint32(chainid),
+			Hash:    txHash[:],
+		})
+		return err
 	})
 	if err != nil {
 		return nil, fmt.Errorf("TxLocalProof: ChainID:%d TxHash:%x failed: %w",
@@ -248,7 +274,7 @@ func (n *xsyncer) _checkOrderId(ctx *cli.Context, orderId common.Hash) (alreadyT
 	return outobj.Exist, nil
 }
 
-func (n *xsyncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof) error {
+func (n *xsyncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof) (errr error) {
 	if len(txProofs) == 0 {
 		return nil
 	}
@@ -259,20 +285,23 @@ func (n *xsyncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof)
 	defer func() {
 		_ = n.sendingLock.Release()
 	}()
+	keeper := keepLease(cctx.Context, n.sendingLock)
+	defer keeper.stop(&errr)
+	cctx = withContext(cctx, keeper.Context())
 
-	dlocks := redisLocks{n.runningLock, n.sendingLock}
-
-	_ = dlocks.Refresh(cctx.Context)
+	dlocks := storeLocks{n.runningLock, n.sendingLock}
 	to := n.conf.XSynchronizer.TargetMSCAddr
-	gas, mustHave := n._targetSuggestBalance(cctx.Context)
-	nonce, err := n.target.nonceWithBalanceMoreThan(cctx.Context, n.targetPriv.Address(), n.conf.TargetCheckBalance, mustHave)
-	if err != nil {
-		return err
-	}
 
-	// send txs
-	var ethtxs []*types.Transaction
-	for i, txProof := range txProofs {
+	// estimate all the txs before sending any of them
+	inputs := make([][]byte, 0, len(txProofs))
+	gases := make([]uint64, 0, len(txProofs))
+	refs := make([]string, 0, len(txProofs))
+	var rejects []string // by preflight, block the cursor
+	simFailed := 0       // by dry-run
+	var totalGas uint64
+	for _, txProof := range txProofs {
+		orderId, _ := transferOutOrderId(txProof.Receipt, n.conf.XSynchronizer.XMCSAddress, n.watchTopicId)
+		ref := fmt.Sprintf("OrderId:%x", orderId[:])
 		// proof, err := T2LN.ReceiptProof(txProof)
 		proof, err := T2LN.ReceiptData(txProof, n.conf.XSynchronizer.XMCSAddress, n.watchTopicId)
 		if err != nil {
@@ -287,29 +316,78 @@ func (n *xsyncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof)
 		if err != nil {
 			return fmt.Errorf("packinput failed: %w", err)
 		}
-		ethtx, _, err := n.target.sendLegacyTx(cctx.Context, n.targetPriv.Priv(), &to, nonce, gas, nil, nil, input)
+		if n.conf.XSynchronizer.Preflight {
+			if err := n._preflight(cctx.Context, data, input); err != nil {
+				log.Errorf("preflight of %s failed, never sent: %v", ref, err)
+				rejects = append(rejects, fmt.Sprintf("%s: %v", ref, err))
+				continue
+			}
+		}
+		if n.conf.DryRun {
+			// the cursor of a dry-run moves on, failed simulations are only reported
+			if err := n._simulateTx(cctx.Context, &to, input, ref); err != nil {
+				log.Errorf("%v", err)
+				simFailed++
+			}
+			continue
+		}
+		gas, err := n._targetEstimateGas(cctx.Context, &to, input)
+		if err != nil {
+			return fmt.Errorf("estimate failed: %w", err)
+		}
+		inputs = append(inputs, input)
+		gases = append(gases, gas)
+		refs = append(refs, ref)
+		totalGas += gas
+	}
+	rejectedErr := func() error {
+		if len(rejects) == 0 {
+			return nil
+		}
+		return fmt.Errorf("%d proofs rejected by preflight: [%s]", len(rejects), strings.Join(rejects, "; "))
+	}
+	if len(inputs) == 0 {
+		n._observeTransfers(transferFailed, len(rejects)+simFailed)
+		return rejectedErr()
+	}
+	mustHave := n._targetSuggestBalance(cctx.Context, totalGas)
+	nonce, err := n.target.nonceWithBalanceMoreThan(cctx.Context, n.targetPriv.Address(), n.conf.TargetCheckBalance, mustHave)
+	if err != nil {
+		return err
+	}
+
+	// send txs
+	var ethtxs []*types.Transaction
+	for i, input := range inputs {
+		jctx := putTxJournal(putDistributedLock(cctx.Context, dlocks), n.journal, refs[i])
+		ethtx, _, err := n.target.sendTx(jctx, n.targetPriv.Priv(), &to, nonce, gases[i], nil, input)
 		if err != nil {
 			return fmt.Errorf("send tx failed: %w", err)
 		}
 		ethtxs = append(ethtxs, ethtx)
 		nonce++
-		if i > 0 && i%10 == 0 {
-			_ = dlocks.Refresh(cctx.Context)
-		}
 	}
 
 	// get receipts
-	rcpts, err := n.target.checkReceipts(putDistributedLock(cctx.Context, dlocks), ethtxs...)
+	waitStart := time.Now()
+	defer n._observeReceiptWait(waitStart)
+	rcpts, err := n.target.checkReceipts(putDistributedLock(putTxJournal(cctx.Context, n.journal, ""), dlocks), n.targetPriv.Priv(), ethtxs...)
 	if err != nil {
 		return fmt.Errorf("get receipt failed: %w", err)
 	}
 	var successes, faileds []common.Hash
+	var reasons []string
 	for i, rpt := range rcpts {
+		if rpt != nil {
+			n._observeGasUsed(rpt.GasUsed)
+		}
 		if rpt != nil && rpt.Success() {
 			successes = append(successes, rpt.TxHash)
 		} else {
 			if rpt != nil {
 				faileds = append(faileds, rpt.TxHash)
+				reason := n._failedReason(cctx.Context, ethtxs[i], rpt)
+				reasons = append(reasons, fmt.Sprintf("%x: %s", rpt.TxHash[:], reason))
 			} else {
 				if i < len(ethtxs) && ethtxs[i] != nil {
 					faileds = append(faileds, E2T.Hash(ethtxs[i].Hash()))
@@ -319,40 +397,33 @@ func (n *xsyncer) _mcsProofs(cctx *cli.Context, txProofs []*models.TxFinalProof)
 			}
 		}
 	}
+	n._observeTransfers(transferRelayed, len(successes))
+	n._observeTransfers(transferFailed, len(faileds)+len(rejects))
 	if len(successes) > 0 {
 		log.Infof("MCS Success: %s", successes)
 	}
 	if len(faileds) > 0 {
 		log.Errorf("MCS failed: %s", faileds)
+		if len(reasons) > 0 {
+			return fmt.Errorf("transfer failed occurs: %d successed, %d failed, reverted: [%s]", len(successes),
+				len(faileds), strings.Join(reasons, "; "))
+		}
 		return fmt.Errorf("transfer failed occurs: %d successed, %d failed", len(successes), len(faileds))
 	}
-	return nil
+	return rejectedErr()
 }
 
-func (n *xsyncer) _lnProofs(cctx *cli.Context, txProofs ...*models.TxFinalProof) error {
-	if len(txProofs) == 0 {
-		return nil
-	}
-	proof, err := T2LN.ReceiptData(txProofs[0], n.conf.XSynchronizer.XMCSAddress, n.watchTopicId)
-	if err != nil {
-		return err
-	}
-	log.Infof("proofs: %s", common.IndentLevel(0).InfoString(proof.Proofs))
-	data, err := XLightNodeAbi.Methods[xVerifyReceiptStruct].Inputs.Pack(proof)
-	if err != nil {
-		return fmt.Errorf("packdata failed: %w", err)
-	}
+// _lnVerify verifies the packed proof data with a static call to the light node
+func (n *xsyncer) _lnVerify(ctx context.Context, data []byte) error {
 	input, err := XLightNodeAbi.Pack(xVerifyReceiptData, data)
 	if err != nil {
 		return fmt.Errorf("packinput failed: %w", err)
 	}
-	log.Infof("input of verify:\n%x", input)
 	to := n.conf.XSynchronizer.TargetLCAddr
-	output, err := n.target.callContract(cctx.Context, n.targetPriv.Address(), &to, 30000000, nil, nil, input)
+	output, err := n.target.callContract(ctx, n.targetPriv.Address(), &to, defaultGas, nil, nil, input)
 	if err != nil {
 		return fmt.Errorf("call failed: %w", err)
 	}
-	log.Infof("return: %x", output)
 	retObj := new(struct {
 		Success  bool   `abi:"success"`
 		Mesage   string `abi:"message"`
@@ -364,5 +435,21 @@ func (n *xsyncer) _lnProofs(cctx *cli.Context, txProofs ...*models.TxFinalProof)
 	if retObj.Success {
 		return nil
 	}
-	return errors.New("verify failed")
+	return fmt.Errorf("verify failed: %s", retObj.Mesage)
+}
+
+// _preflight verifies the proof data by the X-Relay light node, and simulates the transferIn with input on
+// the MCS. Any revert returns an error with the decoded reason.
+func (n *xsyncer) _preflight(ctx context.Context, data, input []byte) error {
+	if err := n._lnVerify(ctx, data); err != nil {
+		return fmt.Errorf("light node: %w", err)
+	}
+	to := n.conf.XSynchronizer.TargetMSCAddr
+	if _, err := n.target.callContract(ctx, n.targetPriv.Address(), &to, defaultGas, nil, nil, input); err != nil {
+		if rerr, ok := asRevertError(err); ok {
+			return fmt.Errorf("transferIn: %w", rerr)
+		}
+		return fmt.Errorf("transferIn call failed: %w", err)
+	}
+	return nil
 }